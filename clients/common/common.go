@@ -166,9 +166,23 @@ func enrollHelper(jwt string) {
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	// updb tokens are redeemed with a password and ottca tokens with a certificate from the identity's CA
 	flags := enroll.EnrollmentFlags{
-		Token:  tkn,
-		KeyAlg: "RSA",
+		Token:    tkn,
+		KeyAlg:   "RSA",
+		Password: os.Getenv("OPENZITI_ENROLL_PASSWORD"),
+		CertFile: os.Getenv("OPENZITI_ENROLL_CERT"),
+		KeyFile:  os.Getenv("OPENZITI_ENROLL_KEY"),
+	}
+	switch tkn.EnrollmentMethod {
+	case "updb":
+		if flags.Password == "" {
+			logrus.Fatalf("%s enrolls with a password, set OPENZITI_ENROLL_PASSWORD", jwt)
+		}
+	case "ottca":
+		if flags.CertFile == "" || flags.KeyFile == "" {
+			logrus.Fatalf("%s enrolls with a certificate signed by its CA, set OPENZITI_ENROLL_CERT and OPENZITI_ENROLL_KEY", jwt)
+		}
 	}
 	conf, err := enroll.Enroll(flags)
 	if err != nil {
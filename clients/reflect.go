@@ -39,10 +39,13 @@ func main() {
 	logrus.Infof("end to end encrypted connection to %s established", serviceName)
 	logrus.Info("you may now type a line to be sent to the server (press enter to send)")
 	logrus.Info("the line will be sent to the reflect server and returned")
+	logrus.Info("type /join <room> to chat in another room, or /join alone to go back to the lobby")
 
 	reader := bufio.NewReader(os.Stdin) //setup a reader for reading input from the commandline
 	conRead := bufio.NewReader(svc)
 	conWrite := bufio.NewWriter(svc)
+	// the room joined last, joined again when the connection has to be redialed
+	room := ""
 
 	for {
 		fmt.Print("Enter some text to send: ")
@@ -51,6 +54,9 @@ func main() {
 			fmt.Println(err)
 			return // exit the program when it reads EOF
 		}
+		if fields := strings.Fields(text); len(fields) > 0 && fields[0] == "/join" {
+			room = strings.Join(fields[1:], " ")
+		}
 		write := true
 		for attempts := 0; write && attempts < 3; attempts++ {
 			if attempts > 0 {
@@ -72,6 +78,13 @@ func main() {
 				fmt.Println("reconnected.")
 				conRead = bufio.NewReader(svc)
 				conWrite = bufio.NewWriter(svc)
+				if room != "" {
+					_, _ = conWrite.WriteString("/join " + room + "\n")
+					if err := conWrite.Flush(); err == nil {
+						joined, _ := conRead.ReadString('\n')
+						fmt.Print("Rejoined: ", joined)
+					}
+				}
 				continue
 			} else {
 				write = false
@@ -5,8 +5,12 @@ go 1.24.10
 require (
 	github.com/TwiN/go-away v1.6.11
 	github.com/caddyserver/certmagic v0.19.2
+	github.com/go-jose/go-jose/v4 v4.0.5
+	github.com/go-openapi/runtime v0.29.2
+	github.com/go-openapi/strfmt v0.25.0
 	github.com/microcosm-cc/bluemonday v1.0.26
 	github.com/openziti/edge-api v0.26.52
+	github.com/openziti/identity v1.0.124
 	github.com/openziti/sdk-golang v1.4.1
 	github.com/sirupsen/logrus v1.9.4
 )
@@ -20,7 +24,6 @@ require (
 	github.com/emirpasic/gods v1.18.1 // indirect
 	github.com/fsnotify/fsnotify v1.9.0 // indirect
 	github.com/fullsailor/pkcs7 v0.0.0-20190404230743-d7302db945fa // indirect
-	github.com/go-jose/go-jose/v4 v4.0.5 // indirect
 	github.com/go-logr/logr v1.4.3 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
@@ -29,9 +32,7 @@ require (
 	github.com/go-openapi/jsonpointer v0.22.1 // indirect
 	github.com/go-openapi/jsonreference v0.21.3 // indirect
 	github.com/go-openapi/loads v0.23.2 // indirect
-	github.com/go-openapi/runtime v0.29.2 // indirect
 	github.com/go-openapi/spec v0.22.1 // indirect
-	github.com/go-openapi/strfmt v0.25.0 // indirect
 	github.com/go-openapi/swag v0.25.1 // indirect
 	github.com/go-openapi/swag/cmdutils v0.25.1 // indirect
 	github.com/go-openapi/swag/conv v0.25.1 // indirect
@@ -78,7 +79,6 @@ require (
 	github.com/openziti/channel/v3 v3.0.26 // indirect
 	github.com/openziti/channel/v4 v4.3.2 // indirect
 	github.com/openziti/foundation/v2 v2.0.86 // indirect
-	github.com/openziti/identity v1.0.124 // indirect
 	github.com/openziti/metrics v1.4.3 // indirect
 	github.com/openziti/secretstream v0.1.47 // indirect
 	github.com/openziti/transport/v2 v2.0.208 // indirect
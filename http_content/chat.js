@@ -15,13 +15,24 @@ function fadeOutElement(element, duration) {
 }
 
 function notifyHandler(event) {
-    const parts = event.data.split(':');
-    const who = parts[0];
-    const what = parts.slice(1).join(':');
+    if (event.lastEventId) {
+        lastEventId = event.lastEventId;
+    }
+    // a ChatEvent: sender, text, timestamp, classification and instance
+    const msg = JSON.parse(event.data);
 
     let d = document.createElement("div");
     d.className = "chat";
-    d.innerHTML = "<p class=\"chatter\">" + who + "</p><p class=\"comment\">" + what + "</p>";
+    d.dataset.classification = msg.classification;
+    d.title = new Date(msg.timestamp).toLocaleString();
+    let who = document.createElement("p");
+    who.className = "chatter";
+    // the reflect service sends the sender and text as sanitized html with special characters escaped
+    who.innerHTML = msg.sender;
+    let what = document.createElement("p");
+    what.className = "comment";
+    what.innerHTML = msg.text;
+    d.append(who, what);
 
     let bubs = document.getElementById("bubs");
     bubs.append(d);
@@ -29,6 +40,11 @@ function notifyHandler(event) {
 }
 
 let connected = false;
+// the id of the last message shown. a new EventSource doesn't send it, so it is passed along to skip
+// the messages already shown when the server replays its history
+let lastEventId = "";
+// the chat room to follow, from the room query parameter of the page. the server's default room when absent
+const room = new URLSearchParams(window.location.search).get("room");
 
 function newEventSourceHandler() {
     if(typeof(EventSource) !== "undefined") {
@@ -44,8 +60,19 @@ function newEventSourceHandler() {
             return;
         }
 
-        console.log("connecting to event source at /sse")
-        source = new EventSource("/sse");
+        const params = new URLSearchParams();
+        if (room) {
+            params.set("room", room);
+        }
+        if (lastEventId !== "") {
+            params.set("lastEventId", lastEventId);
+        }
+        let url = "/sse";
+        if (params.size > 0) {
+            url += "?" + params.toString();
+        }
+        console.log("connecting to event source at " + url)
+        source = new EventSource(url);
         connected = true;
         console.log("CONNECTED TO SSE");
 
@@ -71,4 +98,10 @@ function newEventSourceHandler() {
 
 
 let source = null;
-newEventSourceHandler();
\ No newline at end of file
+newEventSourceHandler();
+
+document.addEventListener("DOMContentLoaded", function() {
+    if (room) {
+        document.getElementById("room").textContent = "(room " + room + ")";
+    }
+});
\ No newline at end of file
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"openziti-test-kitchen/appetizer/manage"
 	"openziti-test-kitchen/appetizer/underlay"
 	"os"
 	"os/signal"
@@ -14,6 +18,14 @@ import (
 )
 
 func main() {
+	plan := flag.Bool("plan", false, "print the controller changes setting up this instance would make, then exit without making them")
+	teardown := flag.Bool("teardown", false, "delete every controller object created for this instance, including visitor identities, then exit")
+	teardownPrefixed := flag.Bool("teardown-prefixed", false, "with -teardown, also delete objects whose names have another _ after the instance prefix, even though they could belong to another instance")
+	provision := flag.String("provision", "", "create a workshop identity for every name in the first column of this CSV file (- for stdin), then exit")
+	enrollment := flag.String("enrollment", "ott", "how the identities created with -provision enroll: ott, updb or ottca")
+	out := flag.String("out", "workshop-identities.zip", "where -provision writes the zip of enrollment tokens")
+	flag.Parse()
+
 	logrus.SetLevel(logrus.DebugLevel)
 	instanceName := ""
 	//logrus.SetLevel(logrus.TraceLevel)
@@ -35,30 +47,83 @@ func main() {
 		logrus.Infof("instanceName set to: %s", instanceName)
 	}
 
-	topic := underlay.Topic[string]{}
-	topic.Start()
-	u := underlay.NewUnderlayServer(topic, instanceName)
+	rooms := underlay.NewRooms[underlay.ChatEvent](underlay.HistorySizeFromEnv())
+	defer rooms.Close()
+	opts, err := manage.OptionsFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	ctrl, err := manage.NewClient(opts)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	auditLog, err := manage.OpenAuditLog(os.Getenv("OPENZITI_AUDIT_LOG"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer func() { _ = auditLog.Close() }()
+	ctrl.SetAuditLog(auditLog)
+	u := underlay.NewUnderlayServer(rooms, instanceName, ctrl)
 
-	recreateNetworkEnv := os.Getenv("OPENZITI_RECREATE_NETWORK")
-	var recreateNetwork bool
-	if recreateNetworkEnv == "" {
-		recreateNetwork = true
-	} else {
-		b, boolParseErr := strconv.ParseBool(recreateNetworkEnv)
-		if boolParseErr != nil {
-			recreateNetwork = false
+	// the network is reconciled unless recreating it is explicitly asked for
+	recreateNetwork := false
+	if recreateNetworkEnv := os.Getenv("OPENZITI_RECREATE_NETWORK"); recreateNetworkEnv != "" {
+		b, err := strconv.ParseBool(recreateNetworkEnv)
+		if err != nil {
+			logrus.Warnf("OPENZITI_RECREATE_NETWORK=%q is not a boolean, reconciling the network instead", recreateNetworkEnv)
 		} else {
 			recreateNetwork = b
 		}
 	}
 
-	serverIdentity := u.Prepare("demo-server", recreateNetwork)
+	ctx := manage.WithRequester(context.Background(), manage.Requester{Source: "startup"})
+	if *teardown {
+		if *plan {
+			report, err := u.PlanTeardown(ctx, *teardownPrefixed)
+			if err != nil {
+				logrus.Fatalf("could not plan the teardown: %v", err)
+			}
+			fmt.Printf("changes planned for %s:\n%s\n", ctrl.CtrlAddress(), report.Diff())
+			return
+		}
+		report, err := u.Teardown(ctx, "demo-server", *teardownPrefixed)
+		if err != nil {
+			logrus.Fatalf("teardown did not complete. removed so far:\n%s\n%v", report.Diff(), err)
+		}
+		fmt.Printf("removed from %s:\n%s\n", ctrl.CtrlAddress(), report.Diff())
+		return
+	}
+
+	if *provision != "" {
+		pctx := manage.WithRequester(ctx, manage.Requester{Source: "provision"})
+		if err := provisionIdentities(pctx, u, *provision, *enrollment, *out); err != nil {
+			logrus.Fatalf("could not provision the workshop identities: %v", err)
+		}
+		return
+	}
+
+	if *plan {
+		report, err := u.Plan(ctx, "demo-server", recreateNetwork)
+		if err != nil {
+			logrus.Fatalf("could not plan the demo network: %v", err)
+		}
+		fmt.Printf("changes planned for %s:\n%s\n", ctrl.CtrlAddress(), report.Diff())
+		return
+	}
+
+	serverIdentity, err := u.Prepare(ctx, "demo-server", recreateNetwork)
+	if err != nil {
+		logrus.Fatalf("could not prepare the demo network: %v", err)
+	}
 	go u.Start()
 
 	go overlay.ServeHTTPOverZiti(serverIdentity, u.HttpServiceName())
+	if underlay.PostureServiceEnabled() {
+		go overlay.ServeHTTPOverZiti(serverIdentity, u.PostureServiceName())
+	}
 	logrus.Infof("started a server listening on the underlay")
 
-	go overlay.StartReflectServer(serverIdentity, u.ReflectServiceName(), topic)
+	go overlay.StartReflectServer(serverIdentity, u.ReflectServiceName(), u.Instance(), rooms)
 	logrus.Infof("started an OpenZiti reflect server")
 
 	logrus.Infof("servers running. waiting for interrupt")
@@ -70,3 +135,48 @@ func main() {
 		logrus.Infof("signal to shutdown received")
 	}
 }
+
+// provisionIdentities creates the workshop identities named in the CSV file and writes their tokens to a zip
+func provisionIdentities(ctx context.Context, u underlay.Server, namesFile string, method string, out string) error {
+	enrollment, err := underlay.ProvisionEnrollment(method)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if namesFile != "-" {
+		if in, err = os.Open(namesFile); err != nil {
+			return err
+		}
+		defer func() { _ = in.Close() }()
+	}
+	names, err := underlay.ParseNames(in)
+	if err != nil {
+		return err
+	}
+
+	provisioned, err := u.Provision(ctx, names, enrollment)
+	tokens := 0
+	for _, p := range provisioned {
+		fmt.Printf("%-10s %s\n", p.Status, p.Name)
+		if p.JWT != "" {
+			tokens++
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := underlay.WriteProvisionZip(f, provisioned); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d enrollment tokens to %s\n", tokens, out)
+	return nil
+}
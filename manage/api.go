@@ -0,0 +1,938 @@
+package manage
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_management_api_client"
+	"github.com/openziti/edge-api/rest_management_api_client/auth_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/config"
+	"github.com/openziti/edge-api/rest_management_api_client/edge_router_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/external_jwt_signer"
+	"github.com/openziti/edge-api/rest_management_api_client/identity"
+	"github.com/openziti/edge-api/rest_management_api_client/posture_checks"
+	"github.com/openziti/edge-api/rest_management_api_client/service"
+	"github.com/openziti/edge-api/rest_management_api_client/service_edge_router_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/service_policy"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/edge-api/rest_util"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/openziti/sdk-golang/ziti/enroll"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	// pageSize is the largest page the controller hands out
+	pageSize = int64(500)
+)
+
+// ControllerAPI is the part of the edge management API appetizer relies on. filters use the
+// controller's filter language (e.g. name = "x") and list calls return every match, not just the
+// first page. errors are expected to be classifiable with errors.Is against ErrNotFound and ErrUnauthorized
+type ControllerAPI interface {
+	ListIdentities(ctx context.Context, filter string) ([]*rest_model.IdentityDetail, error)
+	DetailIdentity(ctx context.Context, id string) (*rest_model.IdentityDetail, error)
+	CreateIdentity(ctx context.Context, create *rest_model.IdentityCreate) (string, error)
+	PatchIdentity(ctx context.Context, id string, patch *rest_model.IdentityPatch) error
+	DeleteIdentity(ctx context.Context, id string) error
+
+	ListServices(ctx context.Context, filter string) ([]*rest_model.ServiceDetail, error)
+	CreateService(ctx context.Context, create *rest_model.ServiceCreate) (string, error)
+	UpdateService(ctx context.Context, id string, update *rest_model.ServiceUpdate) error
+	DeleteService(ctx context.Context, id string) error
+
+	ListServicePolicies(ctx context.Context, filter string) ([]*rest_model.ServicePolicyDetail, error)
+	CreateServicePolicy(ctx context.Context, create *rest_model.ServicePolicyCreate) (string, error)
+	UpdateServicePolicy(ctx context.Context, id string, update *rest_model.ServicePolicyUpdate) error
+	DeleteServicePolicy(ctx context.Context, id string) error
+
+	ListEdgeRouterPolicies(ctx context.Context, filter string) ([]*rest_model.EdgeRouterPolicyDetail, error)
+	CreateEdgeRouterPolicy(ctx context.Context, create *rest_model.EdgeRouterPolicyCreate) (string, error)
+	UpdateEdgeRouterPolicy(ctx context.Context, id string, update *rest_model.EdgeRouterPolicyUpdate) error
+	DeleteEdgeRouterPolicy(ctx context.Context, id string) error
+
+	ListServiceEdgeRouterPolicies(ctx context.Context, filter string) ([]*rest_model.ServiceEdgeRouterPolicyDetail, error)
+	CreateServiceEdgeRouterPolicy(ctx context.Context, create *rest_model.ServiceEdgeRouterPolicyCreate) (string, error)
+	UpdateServiceEdgeRouterPolicy(ctx context.Context, id string, update *rest_model.ServiceEdgeRouterPolicyUpdate) error
+	DeleteServiceEdgeRouterPolicy(ctx context.Context, id string) error
+
+	ListPostureChecks(ctx context.Context, filter string) ([]rest_model.PostureCheckDetail, error)
+	CreatePostureCheck(ctx context.Context, create rest_model.PostureCheckCreate) (string, error)
+	UpdatePostureCheck(ctx context.Context, id string, update rest_model.PostureCheckUpdate) error
+	DeletePostureCheck(ctx context.Context, id string) error
+
+	ListExternalJWTSigners(ctx context.Context, filter string) ([]*rest_model.ExternalJWTSignerDetail, error)
+	CreateExternalJWTSigner(ctx context.Context, create *rest_model.ExternalJWTSignerCreate) (string, error)
+	UpdateExternalJWTSigner(ctx context.Context, id string, update *rest_model.ExternalJWTSignerUpdate) error
+	DeleteExternalJWTSigner(ctx context.Context, id string) error
+
+	ListAuthPolicies(ctx context.Context, filter string) ([]*rest_model.AuthPolicyDetail, error)
+	CreateAuthPolicy(ctx context.Context, create *rest_model.AuthPolicyCreate) (string, error)
+	UpdateAuthPolicy(ctx context.Context, id string, update *rest_model.AuthPolicyUpdate) error
+	DeleteAuthPolicy(ctx context.Context, id string) error
+
+	ListConfigTypes(ctx context.Context, filter string) ([]*rest_model.ConfigTypeDetail, error)
+	ListConfigs(ctx context.Context, filter string) ([]*rest_model.ConfigDetail, error)
+	CreateConfig(ctx context.Context, create *rest_model.ConfigCreate) (string, error)
+	UpdateConfig(ctx context.Context, id string, update *rest_model.ConfigUpdate) error
+	DeleteConfig(ctx context.Context, id string) error
+
+	// Enroll redeems a one-time enrollment JWT and returns the resulting identity configuration
+	Enroll(ctx context.Context, jwt string) (*ziti.Config, error)
+	// Authenticate logs in with an enrolled identity configuration and returns the identity it belongs to
+	Authenticate(ctx context.Context, cfg *ziti.Config) (*rest_model.IdentityDetail, error)
+}
+
+// restAPI implements ControllerAPI against a real controller. it is safe for concurrent use: calls share
+// the current session, which is replaced shortly before it expires or when the controller rejects it
+type restAPI struct {
+	opts   Options
+	caPool *x509.CertPool
+	creds  *adminCredentials
+
+	mu        sync.RWMutex
+	mgmt      *rest_management_api_client.ZitiEdgeManagement
+	expiresAt time.Time
+}
+
+func newRestAPI(opts Options, caPool *x509.CertPool, creds *adminCredentials) (*restAPI, error) {
+	a := &restAPI{
+		opts:   opts,
+		caPool: caPool,
+		creds:  creds,
+	}
+	if err := a.refresh(nil); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// session returns the current management client, refreshing it first if it is about to expire
+func (a *restAPI) session() *rest_management_api_client.ZitiEdgeManagement {
+	a.mu.RLock()
+	mgmt, expiresAt := a.mgmt, a.expiresAt
+	a.mu.RUnlock()
+
+	if !expiresAt.IsZero() && time.Until(expiresAt) < a.opts.RefreshBefore {
+		if err := a.refresh(mgmt); err != nil {
+			logrus.Errorf("could not refresh the management session before it expired: %v", err)
+		}
+		a.mu.RLock()
+		mgmt = a.mgmt
+		a.mu.RUnlock()
+	}
+	return mgmt
+}
+
+// refresh authenticates again unless another call already replaced the stale session
+func (a *restAPI) refresh(stale *rest_management_api_client.ZitiEdgeManagement) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.mgmt != stale {
+		return nil
+	}
+
+	ctrlUrl, err := url.Parse(a.opts.CtrlAddress)
+	if err != nil {
+		return fmt.Errorf("invalid controller address %s: %w", a.opts.CtrlAddress, err)
+	}
+	auth := a.authenticator()
+
+	apiSession, err := auth.Authenticate(ctrlUrl)
+	if err != nil {
+		return fmt.Errorf("could not authenticate to %s: %w", a.opts.CtrlAddress, wrapErr(err))
+	}
+	if apiSession.Token == nil || *apiSession.Token == "" {
+		return fmt.Errorf("could not authenticate to %s: the api session token was empty", a.opts.CtrlAddress)
+	}
+	httpClient, err := auth.BuildHttpClient()
+	if err != nil {
+		return fmt.Errorf("could not build the http client for %s: %w", a.opts.CtrlAddress, err)
+	}
+	mgmt, err := rest_util.NewEdgeManagementClientWithToken(httpClient, a.opts.CtrlAddress, *apiSession.Token)
+	if err != nil {
+		return fmt.Errorf("could not create the management client for %s: %w", a.opts.CtrlAddress, err)
+	}
+
+	a.mgmt = mgmt
+	a.expiresAt = time.Time{}
+	if apiSession.ExpiresAt != nil {
+		a.expiresAt = time.Time(*apiSession.ExpiresAt)
+	}
+	logrus.Debugf("authenticated to %s, session expires at %s", a.opts.CtrlAddress, a.expiresAt)
+	return nil
+}
+
+// authenticator logs in with the admin certificate when there is one, otherwise with username and password
+func (a *restAPI) authenticator() rest_util.Authenticator {
+	if a.creds != nil {
+		auth := rest_util.NewAuthenticatorCert(a.creds.cert, a.creds.key)
+		auth.RootCas = a.caPool
+		return auth
+	}
+	auth := rest_util.NewAuthenticatorUpdb(a.opts.Username, a.opts.Password)
+	auth.RootCas = a.caPool
+	return auth
+}
+
+// call runs fn with the current session. if the controller rejects the session, it is refreshed and
+// fn is retried once
+func (a *restAPI) call(fn func(mgmt *rest_management_api_client.ZitiEdgeManagement) error) error {
+	mgmt := a.session()
+	err := wrapErr(fn(mgmt))
+	if !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	logrus.Infof("the management session was rejected, authenticating again")
+	if rerr := a.refresh(mgmt); rerr != nil {
+		return fmt.Errorf("%w (and %v)", err, rerr)
+	}
+	return wrapErr(fn(a.session()))
+}
+
+// idempotent calls fn like call does, retrying transient failures
+func (a *restAPI) idempotent(ctx context.Context, fn func(mgmt *rest_management_api_client.ZitiEdgeManagement) error) error {
+	return retry(ctx, func() error {
+		return a.call(fn)
+	})
+}
+
+// remove calls a delete like idempotent does. an object that is gone after a retry was deleted by an
+// earlier attempt whose response was lost
+func (a *restAPI) remove(ctx context.Context, fn func(mgmt *rest_management_api_client.ZitiEdgeManagement) error) error {
+	attempts := 0
+	err := retry(ctx, func() error {
+		attempts++
+		return a.call(fn)
+	})
+	if attempts > 1 && errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (a *restAPI) ListIdentities(ctx context.Context, filter string) ([]*rest_model.IdentityDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.IdentityDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &identity.ListIdentitiesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *identity.ListIdentitiesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.Identity.ListIdentities(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+// listAll requests pages until the controller's total count is reached or a page comes back empty
+func listAll[T any](page func(offset int64) ([]T, *rest_model.Meta, error)) ([]T, error) {
+	var all []T
+	for offset := int64(0); ; {
+		data, meta, err := page(offset)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, data...)
+		offset += int64(len(data))
+
+		if len(data) == 0 || meta == nil || meta.Pagination == nil || meta.Pagination.TotalCount == nil {
+			return all, nil
+		}
+		if offset >= *meta.Pagination.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+func (a *restAPI) DetailIdentity(ctx context.Context, id string) (*rest_model.IdentityDetail, error) {
+	params := &identity.DetailIdentityParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *identity.DetailIdentityOK
+	err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.Identity.DetailIdentity(params, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetPayload() == nil || resp.GetPayload().Data == nil {
+		return nil, ErrNotFound
+	}
+	return resp.GetPayload().Data, nil
+}
+
+func (a *restAPI) CreateIdentity(ctx context.Context, create *rest_model.IdentityCreate) (string, error) {
+	params := &identity.CreateIdentityParams{
+		Context:  ctx,
+		Identity: create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *identity.CreateIdentityCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.Identity.CreateIdentity(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) PatchIdentity(ctx context.Context, id string, patch *rest_model.IdentityPatch) error {
+	params := &identity.PatchIdentityParams{
+		Context:  ctx,
+		ID:       id,
+		Identity: patch,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Identity.PatchIdentity(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteIdentity(ctx context.Context, id string) error {
+	params := &identity.DeleteIdentityParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Identity.DeleteIdentity(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListServices(ctx context.Context, filter string) ([]*rest_model.ServiceDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ServiceDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &service.ListServicesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *service.ListServicesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.Service.ListServices(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateService(ctx context.Context, create *rest_model.ServiceCreate) (string, error) {
+	params := &service.CreateServiceParams{
+		Context: ctx,
+		Service: create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *service.CreateServiceCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.Service.CreateService(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateService(ctx context.Context, id string, update *rest_model.ServiceUpdate) error {
+	params := &service.UpdateServiceParams{
+		Context: ctx,
+		ID:      id,
+		Service: update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Service.UpdateService(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteService(ctx context.Context, id string) error {
+	params := &service.DeleteServiceParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Service.DeleteService(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListServicePolicies(ctx context.Context, filter string) ([]*rest_model.ServicePolicyDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ServicePolicyDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &service_policy.ListServicePoliciesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *service_policy.ListServicePoliciesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.ServicePolicy.ListServicePolicies(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateServicePolicy(ctx context.Context, create *rest_model.ServicePolicyCreate) (string, error) {
+	params := &service_policy.CreateServicePolicyParams{
+		Context: ctx,
+		Policy:  create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *service_policy.CreateServicePolicyCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.ServicePolicy.CreateServicePolicy(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateServicePolicy(ctx context.Context, id string, update *rest_model.ServicePolicyUpdate) error {
+	params := &service_policy.UpdateServicePolicyParams{
+		Context: ctx,
+		ID:      id,
+		Policy:  update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ServicePolicy.UpdateServicePolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteServicePolicy(ctx context.Context, id string) error {
+	params := &service_policy.DeleteServicePolicyParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ServicePolicy.DeleteServicePolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListEdgeRouterPolicies(ctx context.Context, filter string) ([]*rest_model.EdgeRouterPolicyDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.EdgeRouterPolicyDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &edge_router_policy.ListEdgeRouterPoliciesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *edge_router_policy.ListEdgeRouterPoliciesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.EdgeRouterPolicy.ListEdgeRouterPolicies(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateEdgeRouterPolicy(ctx context.Context, create *rest_model.EdgeRouterPolicyCreate) (string, error) {
+	params := &edge_router_policy.CreateEdgeRouterPolicyParams{
+		Context: ctx,
+		Policy:  create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *edge_router_policy.CreateEdgeRouterPolicyCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.EdgeRouterPolicy.CreateEdgeRouterPolicy(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateEdgeRouterPolicy(ctx context.Context, id string, update *rest_model.EdgeRouterPolicyUpdate) error {
+	params := &edge_router_policy.UpdateEdgeRouterPolicyParams{
+		Context: ctx,
+		ID:      id,
+		Policy:  update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.EdgeRouterPolicy.UpdateEdgeRouterPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteEdgeRouterPolicy(ctx context.Context, id string) error {
+	params := &edge_router_policy.DeleteEdgeRouterPolicyParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.EdgeRouterPolicy.DeleteEdgeRouterPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListServiceEdgeRouterPolicies(ctx context.Context, filter string) ([]*rest_model.ServiceEdgeRouterPolicyDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ServiceEdgeRouterPolicyDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &service_edge_router_policy.ListServiceEdgeRouterPoliciesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *service_edge_router_policy.ListServiceEdgeRouterPoliciesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.ServiceEdgeRouterPolicy.ListServiceEdgeRouterPolicies(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateServiceEdgeRouterPolicy(ctx context.Context, create *rest_model.ServiceEdgeRouterPolicyCreate) (string, error) {
+	params := &service_edge_router_policy.CreateServiceEdgeRouterPolicyParams{
+		Context: ctx,
+		Policy:  create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *service_edge_router_policy.CreateServiceEdgeRouterPolicyCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.ServiceEdgeRouterPolicy.CreateServiceEdgeRouterPolicy(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateServiceEdgeRouterPolicy(ctx context.Context, id string, update *rest_model.ServiceEdgeRouterPolicyUpdate) error {
+	params := &service_edge_router_policy.UpdateServiceEdgeRouterPolicyParams{
+		Context: ctx,
+		ID:      id,
+		Policy:  update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ServiceEdgeRouterPolicy.UpdateServiceEdgeRouterPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteServiceEdgeRouterPolicy(ctx context.Context, id string) error {
+	params := &service_edge_router_policy.DeleteServiceEdgeRouterPolicyParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ServiceEdgeRouterPolicy.DeleteServiceEdgeRouterPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListPostureChecks(ctx context.Context, filter string) ([]rest_model.PostureCheckDetail, error) {
+	return listAll(func(offset int64) ([]rest_model.PostureCheckDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &posture_checks.ListPostureChecksParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *posture_checks.ListPostureChecksOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.PostureChecks.ListPostureChecks(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data(), resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreatePostureCheck(ctx context.Context, create rest_model.PostureCheckCreate) (string, error) {
+	params := &posture_checks.CreatePostureCheckParams{
+		Context:      ctx,
+		PostureCheck: create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *posture_checks.CreatePostureCheckCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.PostureChecks.CreatePostureCheck(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdatePostureCheck(ctx context.Context, id string, update rest_model.PostureCheckUpdate) error {
+	params := &posture_checks.UpdatePostureCheckParams{
+		Context:      ctx,
+		ID:           id,
+		PostureCheck: update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.PostureChecks.UpdatePostureCheck(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeletePostureCheck(ctx context.Context, id string) error {
+	params := &posture_checks.DeletePostureCheckParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.PostureChecks.DeletePostureCheck(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListConfigTypes(ctx context.Context, filter string) ([]*rest_model.ConfigTypeDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ConfigTypeDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &config.ListConfigTypesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *config.ListConfigTypesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.Config.ListConfigTypes(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) ListConfigs(ctx context.Context, filter string) ([]*rest_model.ConfigDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ConfigDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &config.ListConfigsParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *config.ListConfigsOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.Config.ListConfigs(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateConfig(ctx context.Context, create *rest_model.ConfigCreate) (string, error) {
+	params := &config.CreateConfigParams{
+		Context: ctx,
+		Config:  create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *config.CreateConfigCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.Config.CreateConfig(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateConfig(ctx context.Context, id string, update *rest_model.ConfigUpdate) error {
+	params := &config.UpdateConfigParams{
+		Context: ctx,
+		ID:      id,
+		Config:  update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Config.UpdateConfig(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteConfig(ctx context.Context, id string) error {
+	params := &config.DeleteConfigParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.Config.DeleteConfig(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListExternalJWTSigners(ctx context.Context, filter string) ([]*rest_model.ExternalJWTSignerDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.ExternalJWTSignerDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &external_jwt_signer.ListExternalJWTSignersParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *external_jwt_signer.ListExternalJWTSignersOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.ExternalJWTSigner.ListExternalJWTSigners(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateExternalJWTSigner(ctx context.Context, create *rest_model.ExternalJWTSignerCreate) (string, error) {
+	params := &external_jwt_signer.CreateExternalJWTSignerParams{
+		Context:           ctx,
+		ExternalJWTSigner: create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *external_jwt_signer.CreateExternalJWTSignerCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.ExternalJWTSigner.CreateExternalJWTSigner(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateExternalJWTSigner(ctx context.Context, id string, update *rest_model.ExternalJWTSignerUpdate) error {
+	params := &external_jwt_signer.UpdateExternalJWTSignerParams{
+		Context:           ctx,
+		ID:                id,
+		ExternalJWTSigner: update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ExternalJWTSigner.UpdateExternalJWTSigner(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteExternalJWTSigner(ctx context.Context, id string) error {
+	params := &external_jwt_signer.DeleteExternalJWTSignerParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.ExternalJWTSigner.DeleteExternalJWTSigner(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) ListAuthPolicies(ctx context.Context, filter string) ([]*rest_model.AuthPolicyDetail, error) {
+	return listAll(func(offset int64) ([]*rest_model.AuthPolicyDetail, *rest_model.Meta, error) {
+		limit := pageSize
+		params := &auth_policy.ListAuthPoliciesParams{
+			Context: ctx,
+			Filter:  &filter,
+			Limit:   &limit,
+			Offset:  &offset,
+		}
+		params.SetTimeout(requestTimeout)
+		var resp *auth_policy.ListAuthPoliciesOK
+		err := a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+			resp, err = mgmt.AuthPolicy.ListAuthPolicies(params, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp == nil || resp.Payload == nil {
+			return nil, nil, nil
+		}
+		return resp.Payload.Data, resp.Payload.Meta, nil
+	})
+}
+
+func (a *restAPI) CreateAuthPolicy(ctx context.Context, create *rest_model.AuthPolicyCreate) (string, error) {
+	params := &auth_policy.CreateAuthPolicyParams{
+		Context:    ctx,
+		AuthPolicy: create,
+	}
+	params.SetTimeout(requestTimeout)
+	var resp *auth_policy.CreateAuthPolicyCreated
+	err := a.call(func(mgmt *rest_management_api_client.ZitiEdgeManagement) (err error) {
+		resp, err = mgmt.AuthPolicy.CreateAuthPolicy(params, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPayload().Data.ID, nil
+}
+
+func (a *restAPI) UpdateAuthPolicy(ctx context.Context, id string, update *rest_model.AuthPolicyUpdate) error {
+	params := &auth_policy.UpdateAuthPolicyParams{
+		Context:    ctx,
+		ID:         id,
+		AuthPolicy: update,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.idempotent(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.AuthPolicy.UpdateAuthPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) DeleteAuthPolicy(ctx context.Context, id string) error {
+	params := &auth_policy.DeleteAuthPolicyParams{
+		Context: ctx,
+		ID:      id,
+	}
+	params.SetTimeout(requestTimeout)
+	return a.remove(ctx, func(mgmt *rest_management_api_client.ZitiEdgeManagement) error {
+		_, err := mgmt.AuthPolicy.DeleteAuthPolicy(params, nil)
+		return err
+	})
+}
+
+func (a *restAPI) Enroll(ctx context.Context, jwt string) (*ziti.Config, error) {
+	tkn, _, err := enroll.ParseToken(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the enrollment token: %w", err)
+	}
+
+	flags := enroll.EnrollmentFlags{
+		Token:  tkn,
+		KeyAlg: "RSA",
+	}
+	return untilDone(ctx, func() (*ziti.Config, error) {
+		return enroll.Enroll(flags)
+	})
+}
+
+func (a *restAPI) Authenticate(ctx context.Context, cfg *ziti.Config) (*rest_model.IdentityDetail, error) {
+	return untilDone(ctx, func() (*rest_model.IdentityDetail, error) {
+		zitiCtx, err := ziti.NewContext(cfg)
+		if err != nil {
+			// credentials that can't even be loaded will never be accepted
+			return nil, fmt.Errorf("could not load the credentials: %v: %w", err, ErrUnauthorized)
+		}
+		defer zitiCtx.Close()
+
+		if err := zitiCtx.Authenticate(); err != nil {
+			return nil, wrapErr(err)
+		}
+		return zitiCtx.GetCurrentIdentity()
+	})
+}
+
+// untilDone runs f and stops waiting for it when ctx ends. the sdk's enrollment and authentication take no
+// context, so f can't be cancelled and finishes in the background
+func untilDone[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := f()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,252 @@
+package manage
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const managementPath = "/edge/management/v1"
+
+// fakeManagement serves just enough of the edge management API to exercise restAPI: logging in, listing
+// identities a page at a time and deleting them
+type fakeManagement struct {
+	mu         sync.Mutex
+	identities []string
+	// pageLimit is the largest page handed out, whatever the client asks for
+	pageLimit int
+	// token is the session the server accepts, a new one is issued on every login
+	token  string
+	logins int
+	// offsets are the offsets identities were listed from
+	offsets []int
+	// requests counts the calls made with a session
+	requests int
+	// failures are answered, in order, instead of the next requests
+	failures []int
+	// lost is how many of the next deletes succeed but answer 503, as if the response got lost
+	lost int
+}
+
+func (f *fakeManagement) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, managementPath)
+	if path == "/authenticate" {
+		f.logins++
+		f.token = fmt.Sprintf("session-%d", f.logins)
+		writeEnvelope(w, http.StatusOK, map[string]interface{}{
+			"id":        f.token,
+			"token":     f.token,
+			"expiresAt": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		}, nil)
+		return
+	}
+
+	f.requests++
+	if len(f.failures) > 0 {
+		status := f.failures[0]
+		f.failures = f.failures[1:]
+		writeAPIError(w, status, "FAILED")
+		return
+	}
+	if r.Header.Get("zt-session") != f.token {
+		writeAPIError(w, http.StatusUnauthorized, "UNAUTHORIZED")
+		return
+	}
+
+	switch {
+	case path == "/identities" && r.Method == http.MethodGet:
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		limit = min(limit, f.pageLimit)
+		f.offsets = append(f.offsets, offset)
+		var page []map[string]string
+		for _, name := range f.identities[min(offset, len(f.identities)):min(offset+limit, len(f.identities))] {
+			page = append(page, map[string]string{"id": name, "name": name})
+		}
+		writeEnvelope(w, http.StatusOK, page, map[string]interface{}{
+			"pagination": map[string]int{"limit": limit, "offset": offset, "totalCount": len(f.identities)},
+		})
+	case strings.HasPrefix(path, "/identities/") && r.Method == http.MethodDelete:
+		i := slices.Index(f.identities, strings.TrimPrefix(path, "/identities/"))
+		if i < 0 {
+			writeAPIError(w, http.StatusNotFound, notFoundCode)
+			return
+		}
+		f.identities = slices.Delete(f.identities, i, i+1)
+		if f.lost > 0 {
+			f.lost--
+			writeAPIError(w, http.StatusServiceUnavailable, "FAILED")
+			return
+		}
+		writeEnvelope(w, http.StatusOK, map[string]interface{}{}, nil)
+	default:
+		writeAPIError(w, http.StatusNotFound, notFoundCode)
+	}
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, data interface{}, meta interface{}) {
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data, "meta": meta})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": http.StatusText(status)},
+		"meta":  map[string]interface{}{},
+	})
+}
+
+// newFakeManagement starts a controller holding identities and returns a restAPI logged in to it
+func newFakeManagement(t *testing.T, identities ...string) (*restAPI, *fakeManagement) {
+	t.Helper()
+	f := &fakeManagement{identities: identities, pageLimit: int(pageSize)}
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	a, err := newRestAPI(Options{
+		CtrlAddress:   srv.URL,
+		Username:      "admin",
+		Password:      "admin",
+		RefreshBefore: time.Minute,
+	}, pool, nil)
+	if err != nil {
+		t.Fatalf("could not log in to the fake controller: %v", err)
+	}
+	return a, f
+}
+
+func names(identities []*rest_model.IdentityDetail) []string {
+	var result []string
+	for _, i := range identities {
+		result = append(result, *i.Name)
+	}
+	return result
+}
+
+func TestListIdentitiesReadsEveryPage(t *testing.T) {
+	a, f := newFakeManagement(t, "a", "b", "c", "d", "e")
+	f.pageLimit = 2
+
+	identities, err := a.ListIdentities(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(identities); !slices.Equal(got, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("listed %v", got)
+	}
+	if !slices.Equal(f.offsets, []int{0, 2, 4}) {
+		t.Errorf("pages were requested from offsets %v, expected 0, 2 and 4", f.offsets)
+	}
+}
+
+func TestListAllStopsAtAnEmptyPage(t *testing.T) {
+	total := int64(10)
+	calls := 0
+	all, err := listAll(func(offset int64) ([]int, *rest_model.Meta, error) {
+		calls++
+		meta := &rest_model.Meta{Pagination: &rest_model.Pagination{TotalCount: &total}}
+		if offset >= 3 {
+			// the objects were deleted while they were listed
+			return nil, meta, nil
+		}
+		return []int{1, 2, 3}, meta, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || calls != 2 {
+		t.Errorf("listed %v in %d calls, expected 3 values in 2 calls", all, calls)
+	}
+}
+
+func TestRejectedSessionIsRefreshed(t *testing.T) {
+	a, f := newFakeManagement(t, "a")
+	// the controller restarted and forgot every session
+	f.token = "restarted"
+
+	identities, err := a.ListIdentities(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 {
+		t.Errorf("listed %v", names(identities))
+	}
+	if f.logins != 2 {
+		t.Errorf("logged in %d times, expected a second login after the session was rejected", f.logins)
+	}
+}
+
+func TestExpiringSessionIsRefreshed(t *testing.T) {
+	a, f := newFakeManagement(t, "a")
+	// sessions are issued for an hour, so every session is about to expire
+	a.opts.RefreshBefore = 2 * time.Hour
+
+	if _, err := a.ListIdentities(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if f.logins != 2 {
+		t.Errorf("logged in %d times, expected a second login before the session expired", f.logins)
+	}
+}
+
+func TestTransientFailuresAreRetried(t *testing.T) {
+	a, f := newFakeManagement(t, "a")
+	f.failures = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+
+	identities, err := a.ListIdentities(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || f.requests != 3 {
+		t.Errorf("listed %v in %d requests, expected 1 identity in 3 requests", names(identities), f.requests)
+	}
+}
+
+func TestOtherFailuresAreNotRetried(t *testing.T) {
+	a, f := newFakeManagement(t, "a")
+	f.failures = []int{http.StatusBadRequest}
+
+	if _, err := a.ListIdentities(context.Background(), ""); err == nil {
+		t.Fatal("a rejected request succeeded")
+	}
+	if f.requests != 1 {
+		t.Errorf("a rejected request was sent %d times", f.requests)
+	}
+}
+
+func TestRemoveAfterALostResponse(t *testing.T) {
+	a, f := newFakeManagement(t, "a", "b")
+	f.lost = 1
+
+	if err := a.DeleteIdentity(context.Background(), "a"); err != nil {
+		t.Fatalf("the retried delete of an identity that is gone failed: %v", err)
+	}
+	if !slices.Equal(f.identities, []string{"b"}) {
+		t.Errorf("%v are left", f.identities)
+	}
+	if err := a.DeleteIdentity(context.Background(), "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a missing identity returned %v, expected it not to be found", err)
+	}
+}
@@ -0,0 +1,141 @@
+package manage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Requester describes who caused a controller change. it travels with the request context so the
+// audit log can name them
+type Requester struct {
+	// ClientIP is the address the request came from
+	ClientIP string
+	// ForwardedFor is the X-Forwarded-For header of the request. proxies set it, but so can anyone
+	ForwardedFor string
+	// Instance is the demo instance the request was made to
+	Instance string
+	// Source is what made the change when it wasn't a visitor, such as startup or the reaper
+	Source string
+}
+
+type requesterKey struct{}
+
+// WithRequester returns a context that attributes the changes made with it to the requester
+func WithRequester(ctx context.Context, r Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, r)
+}
+
+// RequesterFrom returns the requester carried by the context, if any
+func RequesterFrom(ctx context.Context) Requester {
+	r, _ := ctx.Value(requesterKey{}).(Requester)
+	return r
+}
+
+// AuditEvent is one line of the audit log
+type AuditEvent struct {
+	Time         time.Time    `json:"time"`
+	ClientIP     string       `json:"clientIp,omitempty"`
+	ForwardedFor string       `json:"forwardedFor,omitempty"`
+	Source       string       `json:"source,omitempty"`
+	Instance     string       `json:"instance,omitempty"`
+	Action       ChangeAction `json:"action"`
+	Type         string       `json:"type"`
+	Name         string       `json:"name,omitempty"`
+	ID           string       `json:"id,omitempty"`
+	Outcome      string       `json:"outcome"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// AuditLog writes every object the client creates, updates or deletes as a line of JSON
+type AuditLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewAuditLog returns an audit log writing to w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w)}
+}
+
+// OpenAuditLog returns an audit log appending to the file at path, or writing to stdout when path is - or
+// stdout. there is no audit log when path is empty
+func OpenAuditLog(path string) (*AuditLog, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-", "stdout":
+		return NewAuditLog(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the audit log %s: %w", path, err)
+	}
+	l := NewAuditLog(f)
+	l.closer = f
+	return l, nil
+}
+
+// Close closes the file the audit log writes to
+func (l *AuditLog) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+func (l *AuditLog) write(ev AuditEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(ev); err != nil {
+		logrus.Errorf("could not write to the audit log: %v", err)
+	}
+}
+
+// SetAuditLog makes the client record its changes to l. nil turns auditing off
+func (c *Client) SetAuditLog(l *AuditLog) {
+	c.auditLog = l
+}
+
+// audit records the outcome of a change. instance is the instance the object belongs to when the change
+// knows it, otherwise the requester's instance is used
+func (c *Client) audit(ctx context.Context, instance string, ch Change, id string, err error) {
+	if c.auditLog == nil {
+		return
+	}
+	r := RequesterFrom(ctx)
+	if instance == "" {
+		instance = r.Instance
+	}
+	ev := AuditEvent{
+		Time:         time.Now().UTC(),
+		ClientIP:     r.ClientIP,
+		ForwardedFor: r.ForwardedFor,
+		Source:       r.Source,
+		Instance:     instance,
+		Action:       ch.Action,
+		Type:         ch.Kind,
+		Name:         ch.Name,
+		ID:           id,
+		Outcome:      OutcomeSuccess,
+	}
+	if err != nil {
+		ev.Outcome = OutcomeFailure
+		ev.Error = err.Error()
+	}
+	c.auditLog.write(ev)
+}
@@ -0,0 +1,98 @@
+package manage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"testing"
+)
+
+func auditEvents(t *testing.T, buf *bytes.Buffer) []manage.AuditEvent {
+	t.Helper()
+	var events []manage.AuditEvent
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var ev manage.AuditEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("the audit log is not json lines: %v", err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestAuditReconcile(t *testing.T) {
+	c, _ := managetest.NewClient()
+	var buf bytes.Buffer
+	c.SetAuditLog(manage.NewAuditLog(&buf))
+	ctx := manage.WithRequester(context.Background(), manage.Requester{Source: "startup"})
+
+	report, err := c.Reconcile(ctx, demo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := auditEvents(t, &buf)
+	if len(events) != len(report.Changes) {
+		t.Fatalf("%d changes were logged as %d events", len(report.Changes), len(events))
+	}
+	for i, ev := range events {
+		ch := report.Changes[i]
+		if ev.Action != ch.Action || ev.Type != ch.Kind || ev.Name != ch.Name || ev.Instance != "test" || ev.Source != "startup" || ev.Outcome != manage.OutcomeSuccess {
+			t.Errorf("%v was logged as %+v", ch, ev)
+		}
+	}
+
+	// an unchanged network logs nothing
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+	if events := auditEvents(t, &buf); len(events) != 0 {
+		t.Errorf("reconciling an unchanged network logged %v", events)
+	}
+}
+
+func TestAuditVisitorChanges(t *testing.T) {
+	c, _ := managetest.NewClient()
+	var buf bytes.Buffer
+	c.SetAuditLog(manage.NewAuditLog(&buf))
+	ctx := manage.WithRequester(context.Background(), manage.Requester{ClientIP: "203.0.113.7", Instance: "test"})
+	visitors := manage.Origin{Instance: "test", Component: manage.ComponentVisitor}
+	amy := manage.Enrollment{Method: manage.EnrollmentOTT, ExternalID: "amy"}
+
+	created, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_amy", nil, amy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_amy2", nil, amy); err == nil {
+		t.Fatal("a second identity with amy's external id was created")
+	}
+	if err := c.DeleteIdentity(ctx, "test_amy"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := auditEvents(t, &buf)
+	if len(events) != 3 {
+		t.Fatalf("expected the create, the failed create and the delete, got %+v", events)
+	}
+	if ev := events[0]; ev.Action != manage.ActionCreate || ev.ID != *created.ID || ev.ClientIP != "203.0.113.7" || ev.Outcome != manage.OutcomeSuccess {
+		t.Errorf("creating amy was logged as %+v", ev)
+	}
+	if ev := events[1]; ev.Name != "test_amy2" || ev.Outcome != manage.OutcomeFailure || ev.Error == "" {
+		t.Errorf("the failed create was logged as %+v", ev)
+	}
+	if ev := events[2]; ev.Action != manage.ActionDelete || ev.ID != *created.ID || ev.Instance != "test" {
+		t.Errorf("deleting amy was logged as %+v", ev)
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	if l, err := manage.OpenAuditLog(""); l != nil || err != nil {
+		t.Errorf("an empty path opened %v, %v", l, err)
+	}
+	if _, err := manage.OpenAuditLog(t.TempDir()); err == nil {
+		t.Error("a directory was opened as the audit log")
+	}
+}
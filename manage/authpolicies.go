@@ -0,0 +1,359 @@
+package manage
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-openapi/strfmt"
+	"github.com/openziti/edge-api/rest_model"
+	"strings"
+)
+
+// ExternalJWTSignerSpec is the desired shape of an external JWT signer, a third party such as an OIDC
+// provider whose JWTs the controller accepts in place of an identity's own credentials
+type ExternalJWTSignerSpec struct {
+	Name         string
+	Issuer       string
+	Audience     string
+	JwksEndpoint string
+	// ClaimsProperty is the claim that names the identity, sub when empty
+	ClaimsProperty string
+	// UseExternalID matches the claim against the external id of identities instead of their id
+	UseExternalID bool
+	// ClientID, Scopes and ExternalAuthURL let clients start the login with the provider themselves
+	ClientID        string
+	Scopes          []string
+	ExternalAuthURL string
+}
+
+// AuthPolicySpec is the desired shape of an auth policy, which decides how its identities may authenticate
+type AuthPolicySpec struct {
+	Name      string
+	AllowCert bool
+	AllowUpdb bool
+	// ExtJWTSigners are the names of the external JWT signers the identities may authenticate with
+	ExtJWTSigners []string
+}
+
+func (spec ExternalJWTSignerSpec) claimsProperty() string {
+	if spec.ClaimsProperty == "" {
+		return "sub"
+	}
+	return spec.ClaimsProperty
+}
+
+func (spec ExternalJWTSignerSpec) create(tags *rest_model.Tags) *rest_model.ExternalJWTSignerCreate {
+	name := spec.Name
+	issuer := spec.Issuer
+	audience := spec.Audience
+	jwks := strfmt.URI(spec.JwksEndpoint)
+	claims := spec.claimsProperty()
+	useExternalID := spec.UseExternalID
+	enabled := true
+	create := &rest_model.ExternalJWTSignerCreate{
+		Name:           &name,
+		Issuer:         &issuer,
+		Audience:       &audience,
+		JwksEndpoint:   &jwks,
+		ClaimsProperty: &claims,
+		UseExternalID:  &useExternalID,
+		Enabled:        &enabled,
+		Scopes:         spec.Scopes,
+		Tags:           tags,
+	}
+	if spec.ClientID != "" {
+		clientID := spec.ClientID
+		create.ClientID = &clientID
+	}
+	if spec.ExternalAuthURL != "" {
+		authURL := spec.ExternalAuthURL
+		create.ExternalAuthURL = &authURL
+	}
+	return create
+}
+
+func (c *Client) planExternalJWTSigners(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	// signers are referenced by auth policies that identities use, so they are updated in place rather
+	// than recreated
+	for _, spec := range desired.ExternalJWTSigners {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListExternalJWTSigners(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list external jwt signers named %s: %w", spec.Name, err)
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "external jwt signer", Name: name, Detail: spec.Issuer},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreateExternalJWTSigner(ctx, spec.create(desired.Origin.tags()))
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if value(actual.Issuer) != spec.Issuer {
+			drift = append(drift, fmt.Sprintf("issuer %s -> %s", value(actual.Issuer), spec.Issuer))
+		}
+		if value(actual.Audience) != spec.Audience {
+			drift = append(drift, fmt.Sprintf("audience %s -> %s", value(actual.Audience), spec.Audience))
+		}
+		if actual.JwksEndpoint == nil || actual.JwksEndpoint.String() != spec.JwksEndpoint {
+			drift = append(drift, "jwksEndpoint")
+		}
+		if value(actual.ClaimsProperty) != spec.claimsProperty() {
+			drift = append(drift, "claimsProperty")
+		}
+		if actual.UseExternalID == nil || *actual.UseExternalID != spec.UseExternalID {
+			drift = append(drift, "useExternalId")
+		}
+		if actual.Enabled == nil || !*actual.Enabled {
+			drift = append(drift, "enabled")
+		}
+		if value(actual.ClientID) != spec.ClientID || value(actual.ExternalAuthURL) != spec.ExternalAuthURL || !sameStrings(actual.Scopes, spec.Scopes) {
+			drift = append(drift, "client login")
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "external jwt signer", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					create := spec.create(desired.Origin.retag(actual.Tags))
+					return c.api.UpdateExternalJWTSigner(ctx, id, &rest_model.ExternalJWTSignerUpdate{
+						Name:            create.Name,
+						Issuer:          create.Issuer,
+						Audience:        create.Audience,
+						JwksEndpoint:    create.JwksEndpoint,
+						ClaimsProperty:  create.ClaimsProperty,
+						UseExternalID:   create.UseExternalID,
+						Enabled:         create.Enabled,
+						ClientID:        create.ClientID,
+						ExternalAuthURL: create.ExternalAuthURL,
+						Scopes:          create.Scopes,
+						Tags:            create.Tags,
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedExternalJWTSigners(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scoped {
+		if !wanted[*s.Name] {
+			steps = append(steps, c.deleteExternalJWTSignerStep(s))
+		}
+	}
+	return steps, nil
+}
+
+func (c *Client) signerIDs(ctx context.Context, names []string) ([]string, error) {
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		existing, err := c.api.ListExternalJWTSigners(ctx, nameFilter(name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list external jwt signers named %s: %w", name, err)
+		}
+		if len(existing) == 0 {
+			return nil, fmt.Errorf("external jwt signer %s: %w", name, ErrNotFound)
+		}
+		ids = append(ids, *existing[0].ID)
+	}
+	return ids, nil
+}
+
+func (spec AuthPolicySpec) create(signers []string, tags *rest_model.Tags) *rest_model.AuthPolicyCreate {
+	name := spec.Name
+	allowCert := spec.AllowCert
+	allowUpdb := spec.AllowUpdb
+	allowExtJWT := len(signers) > 0
+	allowExpired := false
+	noMixedCase, noNumber, noSpecial, noTotp := false, false, false, false
+	var lockout, maxAttempts, minLength int64 = 0, 5, 5
+	return &rest_model.AuthPolicyCreate{
+		Name: &name,
+		Primary: &rest_model.AuthPolicyPrimary{
+			Cert:   &rest_model.AuthPolicyPrimaryCert{Allowed: &allowCert, AllowExpiredCerts: &allowExpired},
+			ExtJWT: &rest_model.AuthPolicyPrimaryExtJWT{Allowed: &allowExtJWT, AllowedSigners: signers},
+			Updb: &rest_model.AuthPolicyPrimaryUpdb{
+				Allowed:                &allowUpdb,
+				LockoutDurationMinutes: &lockout,
+				MaxAttempts:            &maxAttempts,
+				MinPasswordLength:      &minLength,
+				RequireMixedCase:       &noMixedCase,
+				RequireNumberChar:      &noNumber,
+				RequireSpecialChar:     &noSpecial,
+			},
+		},
+		Secondary: &rest_model.AuthPolicySecondary{RequireTotp: &noTotp},
+		Tags:      tags,
+	}
+}
+
+func (c *Client) planAuthPolicies(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	// identities keep referring to their auth policy, so policies are updated in place rather than recreated
+	for _, spec := range desired.AuthPolicies {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListAuthPolicies(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list auth policies named %s: %w", spec.Name, err)
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "auth policy", Name: name},
+				apply: func(ctx context.Context) error {
+					signers, err := c.signerIDs(ctx, spec.ExtJWTSigners)
+					if err != nil {
+						return err
+					}
+					_, err = c.api.CreateAuthPolicy(ctx, spec.create(signers, desired.Origin.tags()))
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		primary := actual.Primary
+		if primary == nil || primary.Cert == nil || value(primary.Cert.Allowed) != spec.AllowCert {
+			drift = append(drift, "cert")
+		}
+		if primary == nil || primary.Updb == nil || value(primary.Updb.Allowed) != spec.AllowUpdb {
+			drift = append(drift, "updb")
+		}
+		// a signer that doesn't exist yet is created before this policy is updated
+		if ids, err := c.signerIDs(ctx, spec.ExtJWTSigners); err != nil || primary == nil || primary.ExtJWT == nil ||
+			!sameStrings(primary.ExtJWT.AllowedSigners, ids) || value(primary.ExtJWT.Allowed) != (len(ids) > 0) {
+			drift = append(drift, "extJwt")
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "auth policy", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					signers, err := c.signerIDs(ctx, spec.ExtJWTSigners)
+					if err != nil {
+						return err
+					}
+					create := spec.create(signers, desired.Origin.retag(actual.Tags))
+					return c.api.UpdateAuthPolicy(ctx, id, &rest_model.AuthPolicyUpdate{AuthPolicyCreate: *create})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedAuthPolicies(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scoped {
+		if !wanted[*p.Name] {
+			steps = append(steps, c.deleteAuthPolicyStep(p))
+		}
+	}
+	return steps, nil
+}
+
+// AuthPolicyID returns the id of the auth policy with the given name
+func (c *Client) AuthPolicyID(ctx context.Context, name string) (string, error) {
+	existing, err := c.api.ListAuthPolicies(ctx, nameFilter(name))
+	if err != nil {
+		return "", fmt.Errorf("could not list auth policies named %s: %w", name, err)
+	}
+	if len(existing) == 0 {
+		return "", fmt.Errorf("auth policy %s: %w", name, ErrNotFound)
+	}
+	return *existing[0].ID, nil
+}
+
+// scopedExternalJWTSigners lists the signers tagged with the origin and the untagged signers whose names start with prefix
+func (c *Client) scopedExternalJWTSigners(ctx context.Context, origin Origin, prefix string) ([]*rest_model.ExternalJWTSignerDetail, error) {
+	var result []*rest_model.ExternalJWTSignerDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListExternalJWTSigners(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list external jwt signers of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListExternalJWTSigners(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list external jwt signers for %s: %w", prefix, err)
+		}
+		for _, s := range named {
+			if isLegacy(*s.Name, s.Tags, prefix) {
+				result = append(result, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+// scopedAuthPolicies lists the auth policies tagged with the origin and the untagged policies whose names start with prefix
+func (c *Client) scopedAuthPolicies(ctx context.Context, origin Origin, prefix string) ([]*rest_model.AuthPolicyDetail, error) {
+	var result []*rest_model.AuthPolicyDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListAuthPolicies(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list auth policies of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListAuthPolicies(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list auth policies for %s: %w", prefix, err)
+		}
+		for _, p := range named {
+			if isLegacy(*p.Name, p.Tags, prefix) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteExternalJWTSignerStep(s *rest_model.ExternalJWTSignerDetail) step {
+	id := *s.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "external jwt signer", Name: *s.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteExternalJWTSigner(ctx, id)
+		},
+	}
+}
+
+func (c *Client) deleteAuthPolicyStep(p *rest_model.AuthPolicyDetail) step {
+	id := *p.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "auth policy", Name: *p.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteAuthPolicy(ctx, id)
+		},
+	}
+}
+
+func value[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,130 @@
+package manage
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_util"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+const defaultRefreshBefore = time.Minute
+
+// Options describe how to reach and authenticate to an OpenZiti controller. the management session
+// authenticates with IdentityFile when set, then CertFile and KeyFile, and otherwise Username and Password
+type Options struct {
+	CtrlAddress string
+	Username    string
+	Password    string
+	// IdentityFile is an enrolled admin identity. its CA and controller are used when CaPool and CtrlAddress are unset
+	IdentityFile string
+	// CertFile and KeyFile are the paths to an admin client certificate and its private key
+	CertFile string
+	KeyFile  string
+	// CaPool verifies the controller. when nil, the controller's well-known CAs are fetched and trusted
+	CaPool *x509.CertPool
+	// RefreshBefore is how long before the management session expires it is replaced. defaults to a minute
+	RefreshBefore time.Duration
+}
+
+// OptionsFromEnv reads OPENZITI_CTRL and the admin credentials: OPENZITI_ADMIN_IDENTITY, or
+// OPENZITI_ADMIN_CERT and OPENZITI_ADMIN_KEY, or OPENZITI_USER and OPENZITI_PWD
+func OptionsFromEnv() (Options, error) {
+	opts := Options{
+		Username:     os.Getenv("OPENZITI_USER"),
+		Password:     os.Getenv("OPENZITI_PWD"),
+		CtrlAddress:  os.Getenv("OPENZITI_CTRL"),
+		IdentityFile: os.Getenv("OPENZITI_ADMIN_IDENTITY"),
+		CertFile:     os.Getenv("OPENZITI_ADMIN_CERT"),
+		KeyFile:      os.Getenv("OPENZITI_ADMIN_KEY"),
+	}
+
+	if opts.IdentityFile != "" {
+		// the controller address and CA come from the identity file unless overridden
+		return opts, nil
+	}
+
+	usesCert := opts.CertFile != "" || opts.KeyFile != ""
+	missing := false
+	check := func(name, value string) {
+		if value == "" {
+			logrus.Errorf("please set the environment variable: %s", name)
+			missing = true
+		}
+	}
+	if usesCert {
+		check("OPENZITI_ADMIN_CERT", opts.CertFile)
+		check("OPENZITI_ADMIN_KEY", opts.KeyFile)
+	} else {
+		check("OPENZITI_USER", opts.Username)
+		check("OPENZITI_PWD", opts.Password)
+	}
+	check("OPENZITI_CTRL", opts.CtrlAddress)
+	if missing {
+		return opts, errors.New("cannot continue until these variables are set")
+	}
+	return opts, nil
+}
+
+// Client manages the objects of a single controller. it adds appetizer's conventions, such as
+// looking objects up by name, on top of a ControllerAPI
+type Client struct {
+	ctrlAddress string
+	api         ControllerAPI
+	auditLog    *AuditLog
+}
+
+// NewClient authenticates to the controller described by opts. the session is refreshed shortly before
+// it expires, or when the controller no longer accepts it
+func NewClient(opts Options) (*Client, error) {
+	creds, err := loadAdminCredentials(opts)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil && opts.CtrlAddress == "" {
+		opts.CtrlAddress = creds.ctrlAddress
+	}
+	if opts.CtrlAddress == "" {
+		return nil, errors.New("a controller address is required")
+	}
+	if opts.RefreshBefore <= 0 {
+		opts.RefreshBefore = defaultRefreshBefore
+	}
+
+	caPool := opts.CaPool
+	if caPool == nil && creds != nil && opts.IdentityFile != "" {
+		caPool = creds.ca
+	}
+	if caPool == nil {
+		caCerts, err := rest_util.GetControllerWellKnownCas(opts.CtrlAddress)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch the well-known CAs from %s: %w", opts.CtrlAddress, err)
+		}
+		caPool = x509.NewCertPool()
+		for _, ca := range caCerts {
+			caPool.AddCert(ca)
+		}
+	}
+
+	api, err := newRestAPI(opts, caPool, creds)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithAPI(opts.CtrlAddress, api), nil
+}
+
+// NewClientWithAPI returns a Client backed by the given ControllerAPI, such as the in-memory
+// controller from the managetest package
+func NewClientWithAPI(ctrlAddress string, api ControllerAPI) *Client {
+	return &Client{
+		ctrlAddress: ctrlAddress,
+		api:         api,
+	}
+}
+
+// CtrlAddress is the address of the controller this client manages
+func (c *Client) CtrlAddress() string {
+	return c.ctrlAddress
+}
@@ -0,0 +1,85 @@
+package manage
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/openziti/identity"
+	"github.com/openziti/sdk-golang/ziti"
+	"net/url"
+)
+
+// adminCredentials hold the client certificate used to authenticate the management session
+type adminCredentials struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+	// ca is the CA bundle that came with the certificate, if any
+	ca *x509.CertPool
+	// ctrlAddress is the controller the identity file was enrolled with, if any
+	ctrlAddress string
+}
+
+// loadAdminCredentials loads the admin identity file or cert/key pair named in opts. it returns nil
+// when opts use a username and password instead
+func loadAdminCredentials(opts Options) (*adminCredentials, error) {
+	switch {
+	case opts.IdentityFile != "":
+		cfg, err := ziti.NewConfigFromFile(opts.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the admin identity file %s: %w", opts.IdentityFile, err)
+		}
+		creds, err := loadCert(cfg.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the admin identity from %s: %w", opts.IdentityFile, err)
+		}
+		creds.ctrlAddress = ctrlAddressOf(cfg)
+		return creds, nil
+	case opts.CertFile != "" || opts.KeyFile != "":
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("both an admin certificate and key are required")
+		}
+		creds, err := loadCert(identity.Config{Cert: opts.CertFile, Key: opts.KeyFile})
+		if err != nil {
+			return nil, fmt.Errorf("could not load the admin certificate %s: %w", opts.CertFile, err)
+		}
+		return creds, nil
+	default:
+		return nil, nil
+	}
+}
+
+func loadCert(cfg identity.Config) (*adminCredentials, error) {
+	id, err := identity.LoadIdentity(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsCert := id.Cert()
+	if tlsCert == nil || len(tlsCert.Certificate) == 0 {
+		return nil, errors.New("no client certificate found")
+	}
+	leaf := tlsCert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(tlsCert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &adminCredentials{
+		cert: leaf,
+		key:  tlsCert.PrivateKey,
+		ca:   id.CA(),
+	}, nil
+}
+
+// ctrlAddressOf returns the scheme and host of the controller an identity file points at
+func ctrlAddressOf(cfg *ziti.Config) string {
+	api := cfg.ZtAPI
+	if api == "" && len(cfg.ZtAPIs) > 0 {
+		api = cfg.ZtAPIs[0]
+	}
+	u, err := url.Parse(api)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
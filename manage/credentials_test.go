@@ -0,0 +1,140 @@
+package manage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeAdminCert writes a self-signed client certificate and its key to dir
+func writeAdminCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "admin.cert")
+	keyFile = filepath.Join(dir, "admin.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadAdminCertificate(t *testing.T) {
+	certFile, keyFile := writeAdminCert(t, t.TempDir())
+
+	creds, err := loadAdminCredentials(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds == nil || creds.cert.Subject.CommonName != "admin" || creds.key == nil {
+		t.Errorf("the admin certificate was not loaded: %+v", creds)
+	}
+
+	if _, err := loadAdminCredentials(Options{CertFile: certFile}); err == nil {
+		t.Error("a certificate without a key was accepted")
+	}
+	if creds, err := loadAdminCredentials(Options{Username: "admin", Password: "admin"}); creds != nil || err != nil {
+		t.Errorf("a username and password loaded credentials %v, %v", creds, err)
+	}
+}
+
+func TestLoadAdminIdentityFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeAdminCert(t, dir)
+	idFile := filepath.Join(dir, "admin.json")
+	cfg, err := json.Marshal(map[string]interface{}{
+		"ztAPI": "https://ctrl.example:1280/edge/client/v1",
+		"id":    map[string]string{"cert": "file://" + certFile, "key": "file://" + keyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(idFile, cfg, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := loadAdminCredentials(Options{IdentityFile: idFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.cert.Subject.CommonName != "admin" {
+		t.Errorf("loaded the certificate of %s", creds.cert.Subject.CommonName)
+	}
+	if creds.ctrlAddress != "https://ctrl.example:1280" {
+		t.Errorf("the identity file points at %q, expected the controller it was enrolled with", creds.ctrlAddress)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	for _, name := range []string{"OPENZITI_USER", "OPENZITI_PWD", "OPENZITI_CTRL", "OPENZITI_ADMIN_IDENTITY", "OPENZITI_ADMIN_CERT", "OPENZITI_ADMIN_KEY"} {
+		t.Setenv(name, "")
+	}
+
+	t.Setenv("OPENZITI_ADMIN_IDENTITY", "admin.json")
+	if _, err := OptionsFromEnv(); err != nil {
+		t.Errorf("an admin identity file alone was refused: %v", err)
+	}
+
+	t.Setenv("OPENZITI_ADMIN_IDENTITY", "")
+	t.Setenv("OPENZITI_CTRL", "https://localhost:1280")
+	t.Setenv("OPENZITI_ADMIN_CERT", "admin.cert")
+	if _, err := OptionsFromEnv(); err == nil {
+		t.Error("an admin certificate without its key was accepted")
+	}
+	t.Setenv("OPENZITI_ADMIN_KEY", "admin.key")
+	if _, err := OptionsFromEnv(); err != nil {
+		t.Errorf("an admin certificate and key were refused: %v", err)
+	}
+}
+
+func TestCertificateLogin(t *testing.T) {
+	certFile, keyFile := writeAdminCert(t, t.TempDir())
+	creds, err := loadAdminCredentials(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeManagement{identities: []string{"a"}, pageLimit: int(pageSize)}
+	srv := httptest.NewTLSServer(f)
+	t.Cleanup(srv.Close)
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	a, err := newRestAPI(Options{CtrlAddress: srv.URL, RefreshBefore: time.Minute}, pool, creds)
+	if err != nil {
+		t.Fatalf("could not log in with the admin certificate: %v", err)
+	}
+	if identities, err := a.ListIdentities(context.Background(), ""); err != nil || len(identities) != 1 {
+		t.Errorf("listed %v, %v", identities, err)
+	}
+}
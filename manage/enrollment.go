@@ -0,0 +1,79 @@
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"strings"
+)
+
+// EnrollmentMethod is how a new identity proves itself to the controller the first time
+type EnrollmentMethod string
+
+const (
+	// EnrollmentOTT enrolls with a one-time token, the controller issues the identity's certificate
+	EnrollmentOTT EnrollmentMethod = "ott"
+	// EnrollmentUPDB enrolls by setting a password for a username, the identity then authenticates with both
+	EnrollmentUPDB EnrollmentMethod = "updb"
+	// EnrollmentOTTCA enrolls with a one-time token and a certificate issued by a third party CA
+	EnrollmentOTTCA EnrollmentMethod = "ottca"
+)
+
+// ParseEnrollmentMethod returns the method named by s, defaulting to EnrollmentOTT when s is empty
+func ParseEnrollmentMethod(s string) (EnrollmentMethod, error) {
+	switch m := EnrollmentMethod(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return EnrollmentOTT, nil
+	case EnrollmentOTT, EnrollmentUPDB, EnrollmentOTTCA:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown enrollment method %q, expected ott, updb or ottca", s)
+}
+
+// Enrollment describes how a new identity enrolls
+type Enrollment struct {
+	Method EnrollmentMethod
+	// Username is the UPDB username, the identity name when empty
+	Username string
+	// CA is the id of the third party CA that signs OTTCA certificates
+	CA string
+	// ExternalID binds the identity to the subject of JWTs from an external JWT signer that uses external ids
+	ExternalID string
+	// AuthPolicyID is the auth policy the identity authenticates under, the default policy when empty
+	AuthPolicyID string
+}
+
+func (e Enrollment) create(identityName string) (*rest_model.IdentityCreateEnrollment, error) {
+	switch e.Method {
+	case "", EnrollmentOTT:
+		return &rest_model.IdentityCreateEnrollment{Ott: true}, nil
+	case EnrollmentUPDB:
+		username := e.Username
+		if username == "" {
+			username = identityName
+		}
+		return &rest_model.IdentityCreateEnrollment{Updb: username}, nil
+	case EnrollmentOTTCA:
+		if e.CA == "" {
+			return nil, errors.New("ottca enrollment needs a CA")
+		}
+		return &rest_model.IdentityCreateEnrollment{Ottca: e.CA}, nil
+	}
+	return nil, fmt.Errorf("unknown enrollment method %q", e.Method)
+}
+
+// PendingEnrollment returns the method and JWT of the enrollment the identity has yet to complete
+func PendingEnrollment(i *rest_model.IdentityDetail) (EnrollmentMethod, string, bool) {
+	if i.Enrollment == nil {
+		return "", "", false
+	}
+	switch {
+	case i.Enrollment.Ott != nil && i.Enrollment.Ott.JWT != "":
+		return EnrollmentOTT, i.Enrollment.Ott.JWT, true
+	case i.Enrollment.Updb != nil && i.Enrollment.Updb.JWT != "":
+		return EnrollmentUPDB, i.Enrollment.Updb.JWT, true
+	case i.Enrollment.Ottca != nil && i.Enrollment.Ottca.JWT != "":
+		return EnrollmentOTTCA, i.Enrollment.Ottca.JWT, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,69 @@
+package manage_test
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"testing"
+)
+
+func TestParseEnrollmentMethod(t *testing.T) {
+	tests := []struct {
+		in   string
+		want manage.EnrollmentMethod
+	}{
+		{in: "", want: manage.EnrollmentOTT},
+		{in: "ott", want: manage.EnrollmentOTT},
+		{in: " UPDB ", want: manage.EnrollmentUPDB},
+		{in: "ottca", want: manage.EnrollmentOTTCA},
+	}
+	for _, tt := range tests {
+		if got, err := manage.ParseEnrollmentMethod(tt.in); err != nil || got != tt.want {
+			t.Errorf("%q parsed as %q, %v, expected %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := manage.ParseEnrollmentMethod("smoke-signals"); err == nil {
+		t.Error("an unknown enrollment method was accepted")
+	}
+}
+
+func TestCreateIdentityEnrollments(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	visitors := manage.Origin{Instance: "test", Component: manage.ComponentVisitor}
+
+	updb, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_amy", nil, manage.Enrollment{Method: manage.EnrollmentUPDB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method, jwt, pending := manage.PendingEnrollment(updb); method != manage.EnrollmentUPDB || jwt == "" || !pending {
+		t.Errorf("amy enrolls with %q %q %t, expected a password enrollment", method, jwt, pending)
+	}
+
+	if _, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_bea", nil, manage.Enrollment{Method: manage.EnrollmentOTTCA}); err == nil {
+		t.Error("a certificate enrollment without a CA was accepted")
+	}
+	ottca, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_bea", nil, manage.Enrollment{Method: manage.EnrollmentOTTCA, CA: "ca"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method, _, _ := manage.PendingEnrollment(ottca); method != manage.EnrollmentOTTCA {
+		t.Errorf("bea enrolls with %q", method)
+	}
+
+	ott, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_cam", nil, manage.Enrollment{Method: manage.EnrollmentOTT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.EnrollIdentity(ctx, *ott.Name); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range ctrl.Identities() {
+		if *i.Name == "test_cam" {
+			if _, _, pending := manage.PendingEnrollment(i); pending {
+				t.Error("cam is still pending enrollment after enrolling")
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package manage
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/go-openapi/runtime"
+	"github.com/openziti/edge-api/rest_util"
+)
+
+var (
+	// ErrNotFound is returned when the controller has no object matching the request
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when the controller rejects the management session
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrIdentityMismatch is returned when enrolled credentials belong to another identity than expected
+	ErrIdentityMismatch = errors.New("identity mismatch")
+	// ErrUnavailable is returned when the controller is overloaded or failed to handle the request
+	ErrUnavailable = errors.New("controller unavailable")
+	// ErrConflict is returned when an object already exists but was created by someone else
+	ErrConflict = errors.New("already exists")
+)
+
+const (
+	notFoundCode     = "NOT_FOUND"
+	unauthorizedCode = "UNAUTHORIZED"
+)
+
+// controllerError keeps the formatted controller error while letting callers use errors.Is
+// against ErrNotFound and ErrUnauthorized
+type controllerError struct {
+	kind error
+	err  error
+}
+
+func (e *controllerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *controllerError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// wrapErr converts an error returned by the edge management client into one that prints the
+// controller's error message and can be classified with errors.Is
+func wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	formatted := rest_util.WrapErr(err)
+
+	var apiFormatted *rest_util.APIFormattedError
+	if errors.As(formatted, &apiFormatted) && apiFormatted.APIError != nil {
+		switch apiFormatted.Code {
+		case notFoundCode:
+			return &controllerError{kind: ErrNotFound, err: formatted}
+		case unauthorizedCode:
+			return &controllerError{kind: ErrUnauthorized, err: formatted}
+		}
+	}
+
+	switch code := statusCode(err); {
+	case code == http.StatusNotFound:
+		return &controllerError{kind: ErrNotFound, err: formatted}
+	case code == http.StatusUnauthorized:
+		return &controllerError{kind: ErrUnauthorized, err: formatted}
+	case code == http.StatusTooManyRequests || code >= http.StatusInternalServerError:
+		return &controllerError{kind: ErrUnavailable, err: formatted}
+	}
+	return formatted
+}
+
+// generated response errors print as "[GET /identities][503] listIdentitiesServiceUnavailable ..."
+var responseStatus = regexp.MustCompile(`^\[[A-Z]+ [^]]*\]\[(\d{3})\]`)
+
+// statusCode returns the http status of a failed controller response, or 0 when the request did not
+// get a response. responses the client has no type for come back as a runtime.APIError. parsing the
+// message is a last resort for generated responses responseCode doesn't know
+func statusCode(err error) int {
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	if code := responseCode(err); code != 0 {
+		return code
+	}
+	if m := responseStatus.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
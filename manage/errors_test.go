@@ -0,0 +1,61 @@
+package manage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-openapi/runtime"
+	"github.com/openziti/edge-api/rest_management_api_client/identity"
+	"github.com/openziti/edge-api/rest_management_api_client/service"
+	"github.com/openziti/edge-api/rest_model"
+	"testing"
+)
+
+func TestWrapErrClassifiesResponses(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"generated not found", identity.NewDetailIdentityNotFound(), ErrNotFound},
+		{"generated unauthorized", service.NewListServicesUnauthorized(), ErrUnauthorized},
+		{"generated rate limit", identity.NewListIdentitiesTooManyRequests(), ErrUnavailable},
+		{"generated unavailable", service.NewDeleteServiceServiceUnavailable(), ErrUnavailable},
+		{"wrapped generated response", fmt.Errorf("deleting: %w", identity.NewDeleteIdentityNotFound()), ErrNotFound},
+		{"api error", runtime.NewAPIError("unknown error", nil, 502), ErrUnavailable},
+		{"error code in the payload", &identity.ListIdentitiesBadRequest{Payload: &rest_model.APIErrorEnvelope{
+			Error: &rest_model.APIError{Code: notFoundCode},
+		}}, ErrNotFound},
+		{"unknown response type", errors.New("[GET /sessions][404] listSessionsNotFound  &{}"), ErrNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := wrapErr(tc.err); !errors.Is(err, tc.want) {
+				t.Errorf("%v is not classified as %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrapErrLeavesOtherErrors(t *testing.T) {
+	for _, err := range []error{
+		identity.NewCreateIdentityBadRequest(),
+		errors.New("dial tcp: connection refused"),
+		errors.New("could not read [GET /identities][404] from the cache"),
+	} {
+		wrapped := wrapErr(err)
+		for _, kind := range []error{ErrNotFound, ErrUnauthorized, ErrUnavailable} {
+			if errors.Is(wrapped, kind) {
+				t.Errorf("%v is classified as %v", err, kind)
+			}
+		}
+	}
+}
+
+func TestResponseCodeUsesTheResponseType(t *testing.T) {
+	if code := responseCode(fmt.Errorf("listing: %w", service.NewListServicesTooManyRequests())); code != 429 {
+		t.Errorf("a rate limited response has status %d", code)
+	}
+	// the message of a response is only parsed as a last resort, by statusCode
+	if code := responseCode(errors.New("[GET /sessions][404] listSessionsNotFound  &{}")); code != 0 {
+		t.Errorf("an untyped error has status %d", code)
+	}
+}
@@ -2,291 +2,256 @@ package manage
 
 import (
 	"context"
-	"crypto/x509"
+	"errors"
 	"fmt"
-	"github.com/openziti/edge-api/rest_management_api_client"
-	"github.com/openziti/edge-api/rest_management_api_client/identity"
-	"github.com/openziti/edge-api/rest_management_api_client/service"
-	"github.com/openziti/edge-api/rest_management_api_client/service_policy"
 	"github.com/openziti/edge-api/rest_model"
-	"github.com/openziti/edge-api/rest_util"
 	"github.com/openziti/sdk-golang/ziti"
-	"github.com/openziti/sdk-golang/ziti/enroll"
-	"github.com/sirupsen/logrus"
-	"os"
-	"time"
 )
 
-var CtrlAddress string
-var DomainName string //if set, will try to use LetsEncrypt to self-bootstrap TLS. __MUST__ listen on 443 if set
-//var DemoInstanceName string //a prefix to use for all objects created by this demo
-
-var client *rest_management_api_client.ZitiEdgeManagement
-
-func init() {
-	zitiAdminUsername := os.Getenv("OPENZITI_USER")
-	zitiAdminPassword := os.Getenv("OPENZITI_PWD")
-	CtrlAddress = os.Getenv("OPENZITI_CTRL")
-	DomainName = os.Getenv("OPENZITI_DOMAIN")
-
-	if zitiAdminUsername == "" || zitiAdminPassword == "" || CtrlAddress == "" {
-		if zitiAdminUsername == "" {
-			logrus.Error("please set the environment variable: OPENZITI_USER")
-		}
-		if zitiAdminPassword == "" {
-			logrus.Error("please set the environment variable: OPENZITI_PWD")
-		}
-		if CtrlAddress == "" {
-			logrus.Error("please set the environment variable: OPENZITI_CTRL")
-		}
-		logrus.Fatal("cannot continue until these variables are set")
+// ListIdentities returns every identity matching the filter
+func (c *Client) ListIdentities(ctx context.Context, filter string) ([]*rest_model.IdentityDetail, error) {
+	ids, err := c.api.ListIdentities(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not list identities matching %s: %w", filter, err)
 	}
+	return ids, nil
+}
 
-	if DomainName != "" {
-		logrus.Infof("domain name is not empty. server will try to bootstrap TLS for domain [%s] on port 443 (required 443)", DomainName)
+// ListServices returns every service matching the filter
+func (c *Client) ListServices(ctx context.Context, filter string) ([]*rest_model.ServiceDetail, error) {
+	svcs, err := c.api.ListServices(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("could not list services matching %s: %w", filter, err)
 	}
+	return svcs, nil
+}
 
-	caCerts, err := rest_util.GetControllerWellKnownCas(CtrlAddress)
+// ListServicePolicies returns every service policy matching the filter
+func (c *Client) ListServicePolicies(ctx context.Context, filter string) ([]*rest_model.ServicePolicyDetail, error) {
+	policies, err := c.api.ListServicePolicies(ctx, filter)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, fmt.Errorf("could not list service policies matching %s: %w", filter, err)
 	}
-	caPool := x509.NewCertPool()
-	for _, ca := range caCerts {
-		caPool.AddCert(ca)
-	}
-	authClient(zitiAdminUsername, zitiAdminPassword, caPool)
-	go renewClientAuth(zitiAdminUsername, zitiAdminPassword, caPool)
+	return policies, nil
 }
 
-func authClient(zitiAdminUsername string, zitiAdminPassword string, caPool *x509.CertPool) {
-	c, err := rest_util.NewEdgeManagementClientWithUpdb(zitiAdminUsername, zitiAdminPassword, CtrlAddress, caPool)
+func (c *Client) FindIdentityDetail(ctx context.Context, identityID string) (*rest_model.IdentityDetail, error) {
+	detail, err := c.api.DetailIdentity(ctx, identityID)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, fmt.Errorf("could not find identity with id %s: %w", identityID, err)
 	}
-	client = c
+	return detail, nil
 }
 
-func renewClientAuth(zitiAdminUsername string, zitiAdminPassword string, caPool *x509.CertPool) {
-	for range time.Tick(time.Minute * 20) {
-		//keep the client alive and logged in
-		authClient(zitiAdminUsername, zitiAdminPassword, caPool)
+// FindIdentity returns the id of the identity with the given name or an empty string if there is no such identity
+func (c *Client) FindIdentity(ctx context.Context, identityName string) (string, error) {
+	ids, err := c.api.ListIdentities(ctx, nameFilter(identityName))
+	if err != nil {
+		return "", fmt.Errorf("could not list identities named %s: %w", identityName, err)
+	}
+	if len(ids) == 0 {
+		return "", nil
 	}
+	return *ids[0].ID, nil
 }
 
-func FindIdentityDetail(identityID string) *rest_model.DetailIdentityEnvelope {
-	// Retrieve and return the JWT token for the given identity ID
-	params := &identity.DetailIdentityParams{
-		Context: context.Background(),
-		ID:      identityID,
-	}
-	params.SetTimeout(30 * time.Second)
-	resp, err := client.Identity.DetailIdentity(params, nil)
+func (c *Client) DeleteIdentity(ctx context.Context, identityName string) error {
+	id, err := c.FindIdentity(ctx, identityName)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return nil
+	}
+	err = c.api.DeleteIdentity(ctx, id)
+	c.audit(ctx, "", Change{Action: ActionDelete, Kind: "identity", Name: identityName}, id, err)
 	if err != nil {
-		logrus.Fatal(err)
+		return fmt.Errorf("could not delete identity %s: %w", identityName, err)
 	}
-	return resp.GetPayload()
+	return nil
 }
 
-func FindIdentity(identityName string) string {
-	searchParam := identity.NewListIdentitiesParams()
-	filter := "name = \"" + identityName + "\""
-	searchParam.Filter = &filter
-	id, err := client.Identity.ListIdentities(searchParam, nil)
+// DeleteOwnedIdentity deletes the identity with the given name if the origin created it. it returns
+// ErrConflict when the name belongs to an identity someone else created, such as a workshop identity
+func (c *Client) DeleteOwnedIdentity(ctx context.Context, origin Origin, identityName string) error {
+	existing, err := c.api.ListIdentities(ctx, nameFilter(identityName))
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("could not list identities named %s: %w", identityName, err)
+	}
+	if len(existing) == 0 {
+		return nil
 	}
-	if id != nil && len(id.Payload.Data) == 0 {
-		return ""
+	i := existing[0]
+	if !origin.owns(i.Tags) {
+		return fmt.Errorf("identity %s was not created by %s: %w", identityName, origin.Instance, ErrConflict)
 	}
-	return *id.Payload.Data[0].ID
+	err = c.api.DeleteIdentity(ctx, *i.ID)
+	c.audit(ctx, origin.Instance, Change{Action: ActionDelete, Kind: "identity", Name: identityName}, *i.ID, err)
+	if err != nil {
+		return fmt.Errorf("could not delete identity %s: %w", identityName, err)
+	}
+	return nil
 }
 
-func DeleteIdentity(identityName string) {
-	id := FindIdentity(identityName)
-	if id == "" {
-		return
+// DeleteIdentityByExternalID deletes the identity bound to the external id, if there is one. it refuses
+// to delete an identity the origin did not create, or to pick one when several share the external id
+func (c *Client) DeleteIdentityByExternalID(ctx context.Context, origin Origin, externalID string) error {
+	existing, err := c.api.ListIdentities(ctx, "externalId="+quoted(externalID))
+	if err != nil {
+		return fmt.Errorf("could not list identities with external id %s: %w", externalID, err)
+	}
+	if len(existing) == 0 {
+		return nil
 	}
-	// logic to delete reflect-server
-	deleteParams := &identity.DeleteIdentityParams{
-		ID: id,
+	if len(existing) > 1 {
+		return fmt.Errorf("%d identities have the external id %s: %w", len(existing), externalID, ErrConflict)
 	}
-	deleteParams.SetTimeout(30 * time.Second)
-	_, err := client.Identity.DeleteIdentity(deleteParams, nil)
+	i := existing[0]
+	if !origin.owns(i.Tags) {
+		return fmt.Errorf("identity %s with the external id %s was not created by %s: %w", *i.Name, externalID, origin.Instance, ErrConflict)
+	}
+	err = c.api.DeleteIdentity(ctx, *i.ID)
+	c.audit(ctx, origin.Instance, Change{Action: ActionDelete, Kind: "identity", Name: *i.Name}, *i.ID, err)
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("could not delete identity %s: %w", *i.Name, err)
 	}
+	return nil
 }
 
-func FindService(serviceName string) string {
-	searchParam := service.NewListServicesParams()
-	filter := "name=\"" + serviceName + "\""
-	searchParam.Filter = &filter
+// DeleteIdentityByID deletes the identity with the given id. name is only used to record the deletion
+func (c *Client) DeleteIdentityByID(ctx context.Context, id string, name string) error {
+	err := c.api.DeleteIdentity(ctx, id)
+	c.audit(ctx, "", Change{Action: ActionDelete, Kind: "identity", Name: name}, id, err)
+	if err != nil {
+		return fmt.Errorf("could not delete identity with id %s: %w", id, err)
+	}
+	return nil
+}
 
-	id, err := client.Service.ListServices(searchParam, nil)
+// FindService returns the id of the service with the given name or an empty string if there is no such service
+func (c *Client) FindService(ctx context.Context, serviceName string) (string, error) {
+	svcs, err := c.api.ListServices(ctx, nameFilter(serviceName))
 	if err != nil {
-		fmt.Println(err)
+		return "", fmt.Errorf("could not list services named %s: %w", serviceName, err)
 	}
-	if id != nil && len(id.Payload.Data) == 0 {
-		return ""
+	if len(svcs) == 0 {
+		return "", nil
 	}
-	return *id.Payload.Data[0].ID
+	return *svcs[0].ID, nil
 }
 
-func DeleteService(serviceName string) {
-	id := FindService(serviceName)
-	if id == "" {
-		return
+func (c *Client) DeleteService(ctx context.Context, serviceName string) error {
+	id, err := c.FindService(ctx, serviceName)
+	if err != nil {
+		return err
 	}
-
-	deleteParams := &service.DeleteServiceParams{
-		ID: id,
+	if id == "" {
+		return nil
 	}
-	deleteParams.SetTimeout(30 * time.Second)
-	_, err := client.Service.DeleteService(deleteParams, nil)
+	err = c.api.DeleteService(ctx, id)
+	c.audit(ctx, "", Change{Action: ActionDelete, Kind: "service", Name: serviceName}, id, err)
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("could not delete service %s: %w", serviceName, err)
 	}
+	return nil
 }
 
-func FindServicePolicy(servicePolicyName string) string {
-	searchParam := service_policy.NewListServicePoliciesParams()
-	filter := "name=\"" + servicePolicyName + "\""
-	searchParam.Filter = &filter
-
-	id, err := client.ServicePolicy.ListServicePolicies(searchParam, nil)
+// FindServicePolicy returns the id of the service policy with the given name or an empty string if there is no such policy
+func (c *Client) FindServicePolicy(ctx context.Context, servicePolicyName string) (string, error) {
+	policies, err := c.api.ListServicePolicies(ctx, nameFilter(servicePolicyName))
 	if err != nil {
-		fmt.Println(err)
+		return "", fmt.Errorf("could not list service policies named %s: %w", servicePolicyName, err)
 	}
-	if id != nil && len(id.Payload.Data) == 0 {
-		return ""
+	if len(policies) == 0 {
+		return "", nil
 	}
-	return *id.Payload.Data[0].ID
+	return *policies[0].ID, nil
 }
 
-func DeleteServicePolicy(servicePolicyName string) {
-	id := FindServicePolicy(servicePolicyName)
-	if id == "" {
-		return
+func (c *Client) DeleteServicePolicy(ctx context.Context, servicePolicyName string) error {
+	id, err := c.FindServicePolicy(ctx, servicePolicyName)
+	if err != nil {
+		return err
 	}
-
-	deleteParams := &service_policy.DeleteServicePolicyParams{
-		ID: id,
+	if id == "" {
+		return nil
 	}
-	deleteParams.SetTimeout(30 * time.Second)
-	_, err := client.ServicePolicy.DeleteServicePolicy(deleteParams, nil)
+	err = c.api.DeleteServicePolicy(ctx, id)
+	c.audit(ctx, "", Change{Action: ActionDelete, Kind: "service policy", Name: servicePolicyName}, id, err)
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("could not delete service policy %s: %w", servicePolicyName, err)
 	}
+	return nil
 }
 
-func CreateService(serviceName string, attribute string) {
-	found := FindService(serviceName)
-	if found != "" {
-		logrus.Infof("service exists. not recreating service: %s", serviceName)
-		return
-	}
-	encryptOn := true
-	serviceCreate := &rest_model.ServiceCreate{
-		EncryptionRequired: &encryptOn,
-		Name:               &serviceName,
-		RoleAttributes:     rest_model.Roles{attribute},
-	}
-	serviceParams := &service.CreateServiceParams{
-		Service: serviceCreate,
-		Context: context.Background(),
-	}
-	serviceParams.SetTimeout(30 * time.Second)
-	_, err := client.Service.CreateService(serviceParams, nil)
+// CreateIdentity creates an identity enrolling with the given method, tagged with its origin, and
+// returns its details, including the enrollment JWT
+func (c *Client) CreateIdentity(ctx context.Context, origin Origin, identType rest_model.IdentityType, identityName string, attributes *rest_model.Attributes, enrollment Enrollment) (*rest_model.IdentityDetail, error) {
+	enroll, err := enrollment.create(identityName)
 	if err != nil {
-		fmt.Println(err)
-		logrus.Fatal("failed to create " + serviceName + " service")
+		return nil, err
 	}
-}
-
-func CreateIdentity(identType rest_model.IdentityType, identityName string, attributes *rest_model.Attributes) *identity.CreateIdentityCreated {
 	var isAdmin bool
 	i := &rest_model.IdentityCreate{
-		Enrollment: &rest_model.IdentityCreateEnrollment{
-			Ott: true,
-		},
+		Enrollment:                enroll,
 		IsAdmin:                   &isAdmin,
 		Name:                      &identityName,
 		RoleAttributes:            attributes,
 		ServiceHostingCosts:       nil,
 		ServiceHostingPrecedences: nil,
-		Tags:                      nil,
+		Tags:                      origin.tags(),
 		Type:                      &identType,
 	}
-	p := identity.NewCreateIdentityParams()
-	p.Identity = i
+	if enrollment.ExternalID != "" {
+		i.ExternalID = &enrollment.ExternalID
+	}
+	if enrollment.AuthPolicyID != "" {
+		i.AuthPolicyID = &enrollment.AuthPolicyID
+	}
 
-	ident, err := client.Identity.CreateIdentity(p, nil)
+	id, err := c.api.CreateIdentity(ctx, i)
+	c.audit(ctx, origin.Instance, Change{Action: ActionCreate, Kind: "identity", Name: identityName}, id, err)
 	if err != nil {
-		fmt.Println(err)
-		logrus.Fatal("failed to create the identity")
+		return nil, fmt.Errorf("failed to create the identity %s: %w", identityName, err)
 	}
 
-	return ident
+	return c.FindIdentityDetail(ctx, id)
 }
 
-func EnrollIdentity(identityName string) *ziti.Config {
-	identityID := FindIdentity(identityName)
-	if identityID == "" {
-		logrus.Fatal("identityID cant be found")
-		return nil
-	}
-
-	params := &identity.DetailIdentityParams{
-		Context: context.Background(),
-		ID:      identityID,
-	}
-	params.SetTimeout(30 * time.Second)
-
-	resp, err := client.Identity.DetailIdentity(params, nil)
+func (c *Client) EnrollIdentity(ctx context.Context, identityName string) (*ziti.Config, error) {
+	identityID, err := c.FindIdentity(ctx, identityName)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, err
+	}
+	if identityID == "" {
+		return nil, fmt.Errorf("identity %s can't be found: %w", identityName, ErrNotFound)
 	}
 
-	tkn, _, err := enroll.ParseToken(resp.GetPayload().Data.Enrollment.Ott.JWT)
+	detail, err := c.FindIdentityDetail(ctx, identityID)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, err
 	}
-
-	flags := enroll.EnrollmentFlags{
-		Token:  tkn,
-		KeyAlg: "RSA",
+	if detail.Enrollment == nil || detail.Enrollment.Ott == nil {
+		return nil, errors.New("identity " + identityName + " has no outstanding one-time token enrollment")
 	}
 
-	conf, err := enroll.Enroll(flags)
+	conf, err := c.api.Enroll(ctx, detail.Enrollment.Ott.JWT)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, fmt.Errorf("could not enroll %s: %w", identityName, err)
 	}
 
-	return conf
+	return conf, nil
 }
 
-func CreateServicePolicy(name string, servType rest_model.DialBind, identityRoles rest_model.Roles, serviceRoles rest_model.Roles) {
-	found := FindServicePolicy(name)
-	if found != "" {
-		logrus.Infof("servicePolicy exists. not recreating policy: %s", name)
-		return
-	}
-	defaultSemantic := rest_model.SemanticAllOf
-	servicePolicy := &rest_model.ServicePolicyCreate{
-		IdentityRoles: identityRoles,
-		Name:          &name,
-		Semantic:      &defaultSemantic,
-		ServiceRoles:  serviceRoles,
-		Type:          &servType,
-	}
-	params := &service_policy.CreateServicePolicyParams{
-		Policy:  servicePolicy,
-		Context: context.Background(),
-	}
-	params.SetTimeout(30 * time.Second)
-	_, err := client.ServicePolicy.CreateServicePolicy(params, nil)
+// VerifyIdentity makes sure the controller still accepts an enrolled identity configuration and
+// that it belongs to the identity with the given name
+func (c *Client) VerifyIdentity(ctx context.Context, identityName string, cfg *ziti.Config) error {
+	detail, err := c.api.Authenticate(ctx, cfg)
 	if err != nil {
-		fmt.Println(err)
-		logrus.Fatal("failed to create the " + name + " service policy")
+		return fmt.Errorf("the controller rejected the credentials of %s: %w", identityName, err)
+	}
+	if detail == nil || detail.Name == nil || *detail.Name != identityName {
+		return fmt.Errorf("the credentials do not belong to %s: %w", identityName, ErrIdentityMismatch)
 	}
+	return nil
 }
@@ -0,0 +1,231 @@
+package managetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"slices"
+)
+
+func (c *Controller) ListExternalJWTSigners(_ context.Context, filter string) ([]*rest_model.ExternalJWTSignerDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.ExternalJWTSignerDetail
+	for _, s := range c.externalJWTSigners.list() {
+		if matches(clauses, externalJWTSignerField(s)) {
+			cp := *s
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func externalJWTSignerField(s *rest_model.ExternalJWTSignerDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*s.Name}
+		case "issuer":
+			return []string{*s.Issuer}
+		}
+		v, _ := baseValues(name, s.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateExternalJWTSigner(_ context.Context, create *rest_model.ExternalJWTSignerCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Issuer == nil || create.Audience == nil {
+		return "", fmt.Errorf("an external jwt signer needs a name, an issuer and an audience")
+	}
+	if create.JwksEndpoint == nil && create.CertPem == nil {
+		return "", fmt.Errorf("an external jwt signer needs a jwks endpoint or a certificate")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.externalJWTSigners.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("an external jwt signer named %s already exists", *create.Name)
+		}
+		if *existing.Issuer == *create.Issuer {
+			return "", fmt.Errorf("an external jwt signer with issuer %s already exists", *create.Issuer)
+		}
+	}
+
+	name := *create.Name
+	detail := &rest_model.ExternalJWTSignerDetail{
+		BaseEntity:      newBaseEntity(create.Tags),
+		Name:            &name,
+		Issuer:          create.Issuer,
+		Audience:        create.Audience,
+		JwksEndpoint:    create.JwksEndpoint,
+		CertPem:         create.CertPem,
+		ClaimsProperty:  create.ClaimsProperty,
+		UseExternalID:   create.UseExternalID,
+		Enabled:         create.Enabled,
+		ClientID:        create.ClientID,
+		ExternalAuthURL: create.ExternalAuthURL,
+		Scopes:          create.Scopes,
+	}
+	c.externalJWTSigners.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateExternalJWTSigner(_ context.Context, id string, update *rest_model.ExternalJWTSignerUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, found := c.externalJWTSigners.items[id]
+	if !found {
+		return notFound("external jwt signer", id)
+	}
+	cp := *s
+	cp.Name = update.Name
+	cp.Issuer = update.Issuer
+	cp.Audience = update.Audience
+	cp.JwksEndpoint = update.JwksEndpoint
+	cp.CertPem = update.CertPem
+	cp.ClaimsProperty = update.ClaimsProperty
+	cp.UseExternalID = update.UseExternalID
+	cp.Enabled = update.Enabled
+	cp.ClientID = update.ClientID
+	cp.ExternalAuthURL = update.ExternalAuthURL
+	cp.Scopes = update.Scopes
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.externalJWTSigners.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteExternalJWTSigner(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.authPolicies.list() {
+		if p.Primary != nil && p.Primary.ExtJWT != nil && slices.Contains(p.Primary.ExtJWT.AllowedSigners, id) {
+			return fmt.Errorf("external jwt signer %s is used by auth policy %s", id, *p.Name)
+		}
+	}
+	if !c.externalJWTSigners.remove(id) {
+		return notFound("external jwt signer", id)
+	}
+	return nil
+}
+
+func (c *Controller) ListAuthPolicies(_ context.Context, filter string) ([]*rest_model.AuthPolicyDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.AuthPolicyDetail
+	for _, p := range c.authPolicies.list() {
+		if matches(clauses, authPolicyField(p)) {
+			cp := *p
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func authPolicyField(p *rest_model.AuthPolicyDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*p.Name}
+		}
+		v, _ := baseValues(name, p.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateAuthPolicy(_ context.Context, create *rest_model.AuthPolicyCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Primary == nil || create.Secondary == nil {
+		return "", fmt.Errorf("an auth policy needs a name, primary and secondary authentication")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.authPolicies.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("an auth policy named %s already exists", *create.Name)
+		}
+	}
+	if err := c.checkSigners(create.Primary); err != nil {
+		return "", err
+	}
+
+	name := *create.Name
+	detail := &rest_model.AuthPolicyDetail{
+		BaseEntity: newBaseEntity(create.Tags),
+		Name:       &name,
+		Primary:    create.Primary,
+		Secondary:  create.Secondary,
+	}
+	c.authPolicies.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateAuthPolicy(_ context.Context, id string, update *rest_model.AuthPolicyUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, found := c.authPolicies.items[id]
+	if !found {
+		return notFound("auth policy", id)
+	}
+	if err := c.checkSigners(update.Primary); err != nil {
+		return err
+	}
+	cp := *p
+	cp.Name = update.Name
+	cp.Primary = update.Primary
+	cp.Secondary = update.Secondary
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.authPolicies.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteAuthPolicy(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range c.identities.list() {
+		if i.AuthPolicyID != nil && *i.AuthPolicyID == id {
+			return fmt.Errorf("auth policy %s is used by identity %s", id, *i.Name)
+		}
+	}
+	if !c.authPolicies.remove(id) {
+		return notFound("auth policy", id)
+	}
+	return nil
+}
+
+// checkSigners makes sure every signer an auth policy allows exists
+func (c *Controller) checkSigners(primary *rest_model.AuthPolicyPrimary) error {
+	if primary == nil || primary.ExtJWT == nil {
+		return nil
+	}
+	for _, id := range primary.ExtJWT.AllowedSigners {
+		if _, found := c.externalJWTSigners.items[id]; !found {
+			return notFound("external jwt signer", id)
+		}
+	}
+	return nil
+}
+
+// ExternalJWTSigners returns every external jwt signer currently stored
+func (c *Controller) ExternalJWTSigners() []*rest_model.ExternalJWTSignerDetail {
+	result, _ := c.ListExternalJWTSigners(context.Background(), "")
+	return result
+}
+
+// AuthPolicies returns every auth policy currently stored
+func (c *Controller) AuthPolicies() []*rest_model.AuthPolicyDetail {
+	result, _ := c.ListAuthPolicies(context.Background(), "")
+	return result
+}
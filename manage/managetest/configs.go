@@ -0,0 +1,149 @@
+package managetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+)
+
+// configTypes are the config types every controller ships with
+var configTypes = []string{"intercept.v1", "host.v1", "host.v2", "ziti-tunneler-client.v1", "ziti-tunneler-server.v1"}
+
+func (c *Controller) ListConfigTypes(_ context.Context, filter string) ([]*rest_model.ConfigTypeDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var result []*rest_model.ConfigTypeDetail
+	for _, t := range configTypes {
+		name := t
+		detail := &rest_model.ConfigTypeDetail{
+			BaseEntity: rest_model.BaseEntity{ID: &name, Tags: &rest_model.Tags{SubTags: map[string]interface{}{}}},
+			Name:       &name,
+		}
+		if matches(clauses, func(field string) []string {
+			if field == "name" {
+				return []string{name}
+			}
+			v, _ := baseValues(field, detail.BaseEntity)
+			return v
+		}) {
+			result = append(result, detail)
+		}
+	}
+	return result, nil
+}
+
+func (c *Controller) ListConfigs(_ context.Context, filter string) ([]*rest_model.ConfigDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.ConfigDetail
+	for _, cfg := range c.configs.list() {
+		if matches(clauses, configField(cfg)) {
+			cp := *cfg
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func configField(cfg *rest_model.ConfigDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*cfg.Name}
+		case "configType", "type":
+			return []string{*cfg.ConfigTypeID}
+		}
+		v, _ := baseValues(name, cfg.BaseEntity)
+		return v
+	}
+}
+
+// configData round-trips data through JSON, the way the controller stores it
+func configData(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	return result, json.Unmarshal(b, &result)
+}
+
+func (c *Controller) CreateConfig(_ context.Context, create *rest_model.ConfigCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.ConfigTypeID == nil {
+		return "", fmt.Errorf("a config needs a name and a config type")
+	}
+	data, err := configData(create.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid config data: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.configs.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("a config named %s already exists", *create.Name)
+		}
+	}
+
+	name := *create.Name
+	typeID := *create.ConfigTypeID
+	detail := &rest_model.ConfigDetail{
+		BaseEntity:   newBaseEntity(create.Tags),
+		Name:         &name,
+		ConfigTypeID: &typeID,
+		ConfigType:   &rest_model.EntityRef{ID: typeID, Name: typeID, Entity: "config-types"},
+		Data:         data,
+	}
+	c.configs.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateConfig(_ context.Context, id string, update *rest_model.ConfigUpdate) error {
+	data, err := configData(update.Data)
+	if err != nil {
+		return fmt.Errorf("invalid config data: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg, found := c.configs.items[id]
+	if !found {
+		return notFound("config", id)
+	}
+	cp := *cfg
+	cp.Name = update.Name
+	cp.Data = data
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.configs.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteConfig(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.services.list() {
+		for _, configID := range s.Configs {
+			if configID == id {
+				return fmt.Errorf("config %s is still used by service %s", id, *s.Name)
+			}
+		}
+	}
+	if !c.configs.remove(id) {
+		return notFound("config", id)
+	}
+	return nil
+}
+
+// Configs returns every config currently stored
+func (c *Controller) Configs() []*rest_model.ConfigDetail {
+	result, _ := c.ListConfigs(context.Background(), "")
+	return result
+}
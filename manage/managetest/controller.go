@@ -0,0 +1,504 @@
+// Package managetest provides an in-memory stand-in for an OpenZiti controller so code built on
+// manage.Client can run without a real controller.
+package managetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-openapi/strfmt"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/identity"
+	"github.com/openziti/sdk-golang/ziti"
+	"openziti-test-kitchen/appetizer/clients/common"
+	"openziti-test-kitchen/appetizer/manage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Address is the controller address reported by clients returned from NewClient
+const Address = "https://managetest.invalid:1280"
+
+// certPrefix marks the placeholder credentials handed out by Enroll
+const certPrefix = "managetest:"
+
+// Controller keeps identities, services, configs, posture checks and the policies between them in memory and implements
+// manage.ControllerAPI
+type Controller struct {
+	mu              sync.Mutex
+	identities      *store[*rest_model.IdentityDetail]
+	services        *store[*rest_model.ServiceDetail]
+	servicePolicies *store[*rest_model.ServicePolicyDetail]
+	configs         *store[*rest_model.ConfigDetail]
+
+	edgeRouterPolicies        *store[*rest_model.EdgeRouterPolicyDetail]
+	serviceEdgeRouterPolicies *store[*rest_model.ServiceEdgeRouterPolicyDetail]
+	postureChecks             *store[map[string]interface{}]
+	externalJWTSigners        *store[*rest_model.ExternalJWTSignerDetail]
+	authPolicies              *store[*rest_model.AuthPolicyDetail]
+}
+
+// NewController returns an empty in-memory controller
+func NewController() *Controller {
+	return &Controller{
+		identities:      newStore[*rest_model.IdentityDetail](),
+		services:        newStore[*rest_model.ServiceDetail](),
+		servicePolicies: newStore[*rest_model.ServicePolicyDetail](),
+		configs:         newStore[*rest_model.ConfigDetail](),
+
+		edgeRouterPolicies:        newStore[*rest_model.EdgeRouterPolicyDetail](),
+		serviceEdgeRouterPolicies: newStore[*rest_model.ServiceEdgeRouterPolicyDetail](),
+		postureChecks:             newStore[map[string]interface{}](),
+		externalJWTSigners:        newStore[*rest_model.ExternalJWTSignerDetail](),
+		authPolicies:              newStore[*rest_model.AuthPolicyDetail](),
+	}
+}
+
+// NewClient returns a manage.Client backed by a new in-memory controller
+func NewClient() (*manage.Client, *Controller) {
+	c := NewController()
+	return manage.NewClientWithAPI(Address, c), c
+}
+
+// store keeps objects by id and remembers the order they were created in
+type store[T any] struct {
+	order []string
+	items map[string]T
+}
+
+func newStore[T any]() *store[T] {
+	return &store[T]{items: map[string]T{}}
+}
+
+func (s *store[T]) add(id string, item T) {
+	s.order = append(s.order, id)
+	s.items[id] = item
+}
+
+func (s *store[T]) remove(id string) bool {
+	if _, found := s.items[id]; !found {
+		return false
+	}
+	delete(s.items, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (s *store[T]) list() []T {
+	result := make([]T, 0, len(s.order))
+	for _, id := range s.order {
+		result = append(result, s.items[id])
+	}
+	return result
+}
+
+func newBaseEntity(tags *rest_model.Tags) rest_model.BaseEntity {
+	id, _ := common.GenerateRandomID(10)
+	now := strfmt.DateTime(time.Now().UTC())
+	if tags == nil {
+		tags = &rest_model.Tags{SubTags: map[string]interface{}{}}
+	}
+	return rest_model.BaseEntity{
+		ID:        &id,
+		CreatedAt: &now,
+		UpdatedAt: &now,
+		Tags:      tags,
+	}
+}
+
+func touch(b *rest_model.BaseEntity) {
+	now := strfmt.DateTime(time.Now().UTC())
+	b.UpdatedAt = &now
+}
+
+func baseValues(name string, b rest_model.BaseEntity) ([]string, bool) {
+	switch name {
+	case "id":
+		return []string{*b.ID}, true
+	}
+	var tags map[string]interface{}
+	if b.Tags != nil {
+		tags = b.Tags.SubTags
+	}
+	return tagValues(name, tags)
+}
+
+func notFound(kind string, id string) error {
+	return fmt.Errorf("%s with id %s: %w", kind, id, manage.ErrNotFound)
+}
+
+func (c *Controller) ListIdentities(_ context.Context, filter string) ([]*rest_model.IdentityDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.IdentityDetail
+	for _, i := range c.identities.list() {
+		if matches(clauses, identityField(i)) {
+			cp := *i
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func identityField(i *rest_model.IdentityDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*i.Name}
+		case "type":
+			return []string{i.Type.Name}
+		case "externalId":
+			if i.ExternalID == nil {
+				return nil
+			}
+			return []string{*i.ExternalID}
+		case "roleAttributes":
+			if i.RoleAttributes == nil {
+				return nil
+			}
+			return *i.RoleAttributes
+		}
+		v, _ := baseValues(name, i.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) DetailIdentity(_ context.Context, id string) (*rest_model.IdentityDetail, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, found := c.identities.items[id]
+	if !found {
+		return nil, notFound("identity", id)
+	}
+	cp := *i
+	return &cp, nil
+}
+
+func (c *Controller) CreateIdentity(_ context.Context, create *rest_model.IdentityCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Type == nil {
+		return "", fmt.Errorf("an identity needs a name and a type")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.identities.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("an identity named %s already exists", *create.Name)
+		}
+		if create.ExternalID != nil && existing.ExternalID != nil && *existing.ExternalID == *create.ExternalID {
+			return "", fmt.Errorf("an identity with external id %s already exists", *create.ExternalID)
+		}
+	}
+	if create.AuthPolicyID != nil {
+		if _, found := c.authPolicies.items[*create.AuthPolicyID]; !found {
+			return "", notFound("auth policy", *create.AuthPolicyID)
+		}
+	}
+
+	name := *create.Name
+	typeName := string(*create.Type)
+	detail := &rest_model.IdentityDetail{
+		BaseEntity:     newBaseEntity(create.Tags),
+		Name:           &name,
+		Type:           &rest_model.EntityRef{ID: typeName, Name: typeName, Entity: "identity-types"},
+		TypeID:         &typeName,
+		RoleAttributes: create.RoleAttributes,
+		IsAdmin:        create.IsAdmin,
+		ExternalID:     create.ExternalID,
+		AuthPolicyID:   create.AuthPolicyID,
+		Enrollment:     &rest_model.IdentityEnrollments{},
+	}
+	if create.Enrollment != nil {
+		token, _ := common.GenerateRandomID(16)
+		expiresAt := strfmt.DateTime(time.Now().Add(24 * time.Hour).UTC())
+		switch {
+		case create.Enrollment.Ott:
+			detail.Enrollment.Ott = &rest_model.IdentityEnrollmentsOtt{
+				ID: token, Token: token, JWT: "managetest." + token, ExpiresAt: expiresAt,
+			}
+		case create.Enrollment.Updb != "":
+			detail.Enrollment.Updb = &rest_model.IdentityEnrollmentsUpdb{
+				ID: token, Token: token, JWT: "managetest." + token, ExpiresAt: expiresAt,
+			}
+		case create.Enrollment.Ottca != "":
+			detail.Enrollment.Ottca = &rest_model.IdentityEnrollmentsOttca{
+				ID: token, Token: token, JWT: "managetest." + token, ExpiresAt: expiresAt, CaID: create.Enrollment.Ottca,
+			}
+		}
+	}
+	c.identities.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) PatchIdentity(_ context.Context, id string, patch *rest_model.IdentityPatch) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, found := c.identities.items[id]
+	if !found {
+		return notFound("identity", id)
+	}
+	cp := *i
+	if patch.Name != nil {
+		cp.Name = patch.Name
+	}
+	if patch.Type != "" {
+		typeName := string(patch.Type)
+		cp.Type = &rest_model.EntityRef{ID: typeName, Name: typeName, Entity: "identity-types"}
+		cp.TypeID = &typeName
+	}
+	if patch.RoleAttributes != nil {
+		cp.RoleAttributes = patch.RoleAttributes
+	}
+	if patch.Tags != nil {
+		cp.Tags = patch.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.identities.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteIdentity(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.identities.remove(id) {
+		return notFound("identity", id)
+	}
+	return nil
+}
+
+func (c *Controller) ListServices(_ context.Context, filter string) ([]*rest_model.ServiceDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.ServiceDetail
+	for _, s := range c.services.list() {
+		if matches(clauses, serviceField(s)) {
+			cp := *s
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func serviceField(s *rest_model.ServiceDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*s.Name}
+		case "roleAttributes":
+			if s.RoleAttributes == nil {
+				return nil
+			}
+			return *s.RoleAttributes
+		}
+		v, _ := baseValues(name, s.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateService(_ context.Context, create *rest_model.ServiceCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" {
+		return "", fmt.Errorf("a service needs a name")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.services.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("a service named %s already exists", *create.Name)
+		}
+	}
+
+	name := *create.Name
+	attrs := rest_model.Attributes(create.RoleAttributes)
+	detail := &rest_model.ServiceDetail{
+		BaseEntity:         newBaseEntity(create.Tags),
+		Name:               &name,
+		EncryptionRequired: create.EncryptionRequired,
+		RoleAttributes:     &attrs,
+		Configs:            create.Configs,
+	}
+	c.services.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateService(_ context.Context, id string, update *rest_model.ServiceUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, found := c.services.items[id]
+	if !found {
+		return notFound("service", id)
+	}
+	cp := *s
+	attrs := rest_model.Attributes(update.RoleAttributes)
+	cp.Name = update.Name
+	cp.EncryptionRequired = &update.EncryptionRequired
+	cp.RoleAttributes = &attrs
+	cp.Configs = update.Configs
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.services.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteService(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.services.remove(id) {
+		return notFound("service", id)
+	}
+	return nil
+}
+
+func (c *Controller) ListServicePolicies(_ context.Context, filter string) ([]*rest_model.ServicePolicyDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.ServicePolicyDetail
+	for _, p := range c.servicePolicies.list() {
+		if matches(clauses, servicePolicyField(p)) {
+			cp := *p
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func servicePolicyField(p *rest_model.ServicePolicyDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*p.Name}
+		case "type":
+			return []string{string(*p.Type)}
+		}
+		v, _ := baseValues(name, p.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateServicePolicy(_ context.Context, create *rest_model.ServicePolicyCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Type == nil || create.Semantic == nil {
+		return "", fmt.Errorf("a service policy needs a name, a type and a semantic")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.servicePolicies.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("a service policy named %s already exists", *create.Name)
+		}
+	}
+
+	name := *create.Name
+	detail := &rest_model.ServicePolicyDetail{
+		BaseEntity:        newBaseEntity(create.Tags),
+		Name:              &name,
+		Type:              create.Type,
+		Semantic:          create.Semantic,
+		IdentityRoles:     create.IdentityRoles,
+		ServiceRoles:      create.ServiceRoles,
+		PostureCheckRoles: create.PostureCheckRoles,
+	}
+	c.servicePolicies.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateServicePolicy(_ context.Context, id string, update *rest_model.ServicePolicyUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, found := c.servicePolicies.items[id]
+	if !found {
+		return notFound("service policy", id)
+	}
+	cp := *p
+	cp.Name = update.Name
+	cp.Type = update.Type
+	cp.Semantic = update.Semantic
+	cp.IdentityRoles = update.IdentityRoles
+	cp.ServiceRoles = update.ServiceRoles
+	cp.PostureCheckRoles = update.PostureCheckRoles
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.servicePolicies.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteServicePolicy(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.servicePolicies.remove(id) {
+		return notFound("service policy", id)
+	}
+	return nil
+}
+
+// Enroll redeems a one-time token issued by this controller. the returned configuration points
+// at Address and carries placeholder credentials, so it can't be used to dial a real network
+func (c *Controller) Enroll(_ context.Context, jwt string) (*ziti.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range c.identities.list() {
+		if i.Enrollment != nil && i.Enrollment.Ott != nil && i.Enrollment.Ott.JWT == jwt {
+			i.Enrollment = &rest_model.IdentityEnrollments{}
+			return &ziti.Config{
+				ZtAPI: Address + "/edge/client/v1",
+				ID: identity.Config{
+					Cert: certPrefix + *i.ID,
+					Key:  certPrefix + *i.ID,
+				},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("enrollment token %s: %w", strings.TrimPrefix(jwt, "managetest."), manage.ErrNotFound)
+}
+
+// Authenticate accepts configurations returned by Enroll for identities that still exist
+func (c *Controller) Authenticate(_ context.Context, cfg *ziti.Config) (*rest_model.IdentityDetail, error) {
+	id, ok := strings.CutPrefix(cfg.ID.Cert, certPrefix)
+	if !ok {
+		return nil, fmt.Errorf("credentials were not issued by managetest: %w", manage.ErrUnauthorized)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, found := c.identities.items[id]
+	if !found {
+		return nil, fmt.Errorf("identity %s no longer exists: %w", id, manage.ErrUnauthorized)
+	}
+	cp := *i
+	return &cp, nil
+}
+
+// Identities returns every identity currently stored
+func (c *Controller) Identities() []*rest_model.IdentityDetail {
+	result, _ := c.ListIdentities(context.Background(), "")
+	return result
+}
+
+// Services returns every service currently stored
+func (c *Controller) Services() []*rest_model.ServiceDetail {
+	result, _ := c.ListServices(context.Background(), "")
+	return result
+}
+
+// ServicePolicies returns every service policy currently stored
+func (c *Controller) ServicePolicies() []*rest_model.ServicePolicyDetail {
+	result, _ := c.ListServicePolicies(context.Background(), "")
+	return result
+}
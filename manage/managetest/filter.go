@@ -0,0 +1,92 @@
+package managetest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// clause is a single comparison of a filter, e.g. name = "demo-server"
+type clause struct {
+	field string
+	op    string
+	value string
+}
+
+// clauseRegex matches the clause at the start of a filter and the "and" joining it to the next one
+var clauseRegex = regexp.MustCompile(`^\s*([\w.]+)\s*(!=|=|contains|startswith)\s*"((?:[^"\\]|\\.)*)"\s*(?:(?i:and)\s+|$)`)
+var unescaper = regexp.MustCompile(`\\(.)`)
+
+// parseFilter understands the subset of the controller's filter language appetizer uses: quoted
+// string comparisons joined with "and"
+func parseFilter(filter string) ([]clause, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" || filter == "true" {
+		return nil, nil
+	}
+	var clauses []clause
+	for rest := filter; rest != ""; {
+		m := clauseRegex.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("unsupported filter clause: %s", rest)
+		}
+		clauses = append(clauses, clause{
+			field: m[1],
+			op:    m[2],
+			value: unescaper.ReplaceAllString(m[3], "$1"),
+		})
+		rest = rest[len(m[0]):]
+	}
+	return clauses, nil
+}
+
+// matches evaluates every clause against the values the field function reports for an object.
+// a clause holds when any value of a multi-valued field satisfies it
+func matches(clauses []clause, field func(name string) []string) bool {
+	for _, c := range clauses {
+		if !c.holds(field(c.field)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c clause) holds(values []string) bool {
+	if c.op == "!=" {
+		for _, v := range values {
+			if v == c.value {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		switch c.op {
+		case "=":
+			if v == c.value {
+				return true
+			}
+		case "contains":
+			if strings.Contains(v, c.value) {
+				return true
+			}
+		case "startswith":
+			if strings.HasPrefix(v, c.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tagValues reads a tags.<key> field
+func tagValues(name string, tags map[string]interface{}) ([]string, bool) {
+	key, ok := strings.CutPrefix(name, "tags.")
+	if !ok {
+		return nil, false
+	}
+	if v, found := tags[key]; found {
+		return []string{fmt.Sprint(v)}, true
+	}
+	return nil, true
+}
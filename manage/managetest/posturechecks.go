@@ -0,0 +1,146 @@
+package managetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/strfmt"
+	"github.com/openziti/edge-api/rest_model"
+	"time"
+)
+
+// posture checks come in several types behind one interface, so they are kept as their json fields and
+// decoded into a fresh detail whenever they are read
+
+func (c *Controller) ListPostureChecks(_ context.Context, filter string) ([]rest_model.PostureCheckDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []rest_model.PostureCheckDetail
+	for _, fields := range c.postureChecks.list() {
+		p, err := postureCheckDetail(fields)
+		if err != nil {
+			return nil, err
+		}
+		if matches(clauses, postureCheckField(p)) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func postureCheckField(p rest_model.PostureCheckDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*p.Name()}
+		case "typeId":
+			return []string{p.TypeID()}
+		}
+		v, _ := baseValues(name, rest_model.BaseEntity{ID: p.ID(), Tags: p.Tags()})
+		return v
+	}
+}
+
+func (c *Controller) CreatePostureCheck(_ context.Context, create rest_model.PostureCheckCreate) (string, error) {
+	if create == nil || create.Name() == nil || *create.Name() == "" {
+		return "", fmt.Errorf("a posture check needs a name")
+	}
+	fields, err := jsonFields(create)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.postureChecks.list() {
+		if existing["name"] == *create.Name() {
+			return "", fmt.Errorf("a posture check named %s already exists", *create.Name())
+		}
+	}
+
+	base := newBaseEntity(create.Tags())
+	fields["id"] = *base.ID
+	fields["createdAt"] = base.CreatedAt
+	fields["updatedAt"] = base.UpdatedAt
+	fields["tags"] = base.Tags
+	fields["version"] = 1
+	fields["_links"] = rest_model.Links{}
+	if _, err := postureCheckDetail(fields); err != nil {
+		return "", err
+	}
+	c.postureChecks.add(*base.ID, fields)
+	return *base.ID, nil
+}
+
+func (c *Controller) UpdatePostureCheck(_ context.Context, id string, update rest_model.PostureCheckUpdate) error {
+	changes, err := jsonFields(update)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fields, found := c.postureChecks.items[id]
+	if !found {
+		return notFound("posture check", id)
+	}
+	if changes["typeId"] != fields["typeId"] {
+		return fmt.Errorf("posture check %s is a %v check and can't become a %v check", id, fields["typeId"], changes["typeId"])
+	}
+	cp := map[string]interface{}{}
+	for k, v := range fields {
+		cp[k] = v
+	}
+	for k, v := range changes {
+		if k == "tags" && update.Tags() == nil {
+			continue
+		}
+		cp[k] = v
+	}
+	cp["updatedAt"] = strfmt.DateTime(time.Now().UTC())
+	cp["version"] = cp["version"].(int) + 1
+	if _, err := postureCheckDetail(cp); err != nil {
+		return err
+	}
+	c.postureChecks.items[id] = cp
+	return nil
+}
+
+func (c *Controller) DeletePostureCheck(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.postureChecks.remove(id) {
+		return notFound("posture check", id)
+	}
+	return nil
+}
+
+// PostureChecks returns every posture check currently stored
+func (c *Controller) PostureChecks() []rest_model.PostureCheckDetail {
+	result, _ := c.ListPostureChecks(context.Background(), "")
+	return result
+}
+
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func postureCheckDetail(fields map[string]interface{}) (rest_model.PostureCheckDetail, error) {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return rest_model.UnmarshalPostureCheckDetail(bytes.NewReader(b), runtime.JSONConsumer())
+}
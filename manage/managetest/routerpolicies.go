@@ -0,0 +1,183 @@
+package managetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+)
+
+func (c *Controller) ListEdgeRouterPolicies(_ context.Context, filter string) ([]*rest_model.EdgeRouterPolicyDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.EdgeRouterPolicyDetail
+	for _, p := range c.edgeRouterPolicies.list() {
+		if matches(clauses, edgeRouterPolicyField(p)) {
+			cp := *p
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func edgeRouterPolicyField(p *rest_model.EdgeRouterPolicyDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*p.Name}
+		}
+		v, _ := baseValues(name, p.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateEdgeRouterPolicy(_ context.Context, create *rest_model.EdgeRouterPolicyCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Semantic == nil {
+		return "", fmt.Errorf("an edge router policy needs a name and a semantic")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.edgeRouterPolicies.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("an edge router policy named %s already exists", *create.Name)
+		}
+	}
+
+	name := *create.Name
+	isSystem := false
+	detail := &rest_model.EdgeRouterPolicyDetail{
+		BaseEntity:      newBaseEntity(create.Tags),
+		Name:            &name,
+		Semantic:        create.Semantic,
+		IdentityRoles:   create.IdentityRoles,
+		EdgeRouterRoles: create.EdgeRouterRoles,
+		IsSystem:        &isSystem,
+	}
+	c.edgeRouterPolicies.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateEdgeRouterPolicy(_ context.Context, id string, update *rest_model.EdgeRouterPolicyUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, found := c.edgeRouterPolicies.items[id]
+	if !found {
+		return notFound("edge router policy", id)
+	}
+	cp := *p
+	cp.Name = update.Name
+	cp.Semantic = update.Semantic
+	cp.IdentityRoles = update.IdentityRoles
+	cp.EdgeRouterRoles = update.EdgeRouterRoles
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.edgeRouterPolicies.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteEdgeRouterPolicy(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.edgeRouterPolicies.remove(id) {
+		return notFound("edge router policy", id)
+	}
+	return nil
+}
+
+func (c *Controller) ListServiceEdgeRouterPolicies(_ context.Context, filter string) ([]*rest_model.ServiceEdgeRouterPolicyDetail, error) {
+	clauses, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []*rest_model.ServiceEdgeRouterPolicyDetail
+	for _, p := range c.serviceEdgeRouterPolicies.list() {
+		if matches(clauses, serviceEdgeRouterPolicyField(p)) {
+			cp := *p
+			result = append(result, &cp)
+		}
+	}
+	return result, nil
+}
+
+func serviceEdgeRouterPolicyField(p *rest_model.ServiceEdgeRouterPolicyDetail) func(string) []string {
+	return func(name string) []string {
+		switch name {
+		case "name":
+			return []string{*p.Name}
+		}
+		v, _ := baseValues(name, p.BaseEntity)
+		return v
+	}
+}
+
+func (c *Controller) CreateServiceEdgeRouterPolicy(_ context.Context, create *rest_model.ServiceEdgeRouterPolicyCreate) (string, error) {
+	if create.Name == nil || *create.Name == "" || create.Semantic == nil {
+		return "", fmt.Errorf("a service edge router policy needs a name and a semantic")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.serviceEdgeRouterPolicies.list() {
+		if *existing.Name == *create.Name {
+			return "", fmt.Errorf("a service edge router policy named %s already exists", *create.Name)
+		}
+	}
+
+	name := *create.Name
+	detail := &rest_model.ServiceEdgeRouterPolicyDetail{
+		BaseEntity:      newBaseEntity(create.Tags),
+		Name:            &name,
+		Semantic:        create.Semantic,
+		ServiceRoles:    create.ServiceRoles,
+		EdgeRouterRoles: create.EdgeRouterRoles,
+	}
+	c.serviceEdgeRouterPolicies.add(*detail.ID, detail)
+	return *detail.ID, nil
+}
+
+func (c *Controller) UpdateServiceEdgeRouterPolicy(_ context.Context, id string, update *rest_model.ServiceEdgeRouterPolicyUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, found := c.serviceEdgeRouterPolicies.items[id]
+	if !found {
+		return notFound("service edge router policy", id)
+	}
+	cp := *p
+	cp.Name = update.Name
+	cp.Semantic = update.Semantic
+	cp.ServiceRoles = update.ServiceRoles
+	cp.EdgeRouterRoles = update.EdgeRouterRoles
+	if update.Tags != nil {
+		cp.Tags = update.Tags
+	}
+	touch(&cp.BaseEntity)
+	c.serviceEdgeRouterPolicies.items[id] = &cp
+	return nil
+}
+
+func (c *Controller) DeleteServiceEdgeRouterPolicy(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.serviceEdgeRouterPolicies.remove(id) {
+		return notFound("service edge router policy", id)
+	}
+	return nil
+}
+
+// EdgeRouterPolicies returns every edge router policy currently stored
+func (c *Controller) EdgeRouterPolicies() []*rest_model.EdgeRouterPolicyDetail {
+	result, _ := c.ListEdgeRouterPolicies(context.Background(), "")
+	return result
+}
+
+// ServiceEdgeRouterPolicies returns every service edge router policy currently stored
+func (c *Controller) ServiceEdgeRouterPolicies() []*rest_model.ServiceEdgeRouterPolicyDetail {
+	result, _ := c.ListServiceEdgeRouterPolicies(context.Background(), "")
+	return result
+}
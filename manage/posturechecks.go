@@ -0,0 +1,280 @@
+package manage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// PostureCheckSpec is the desired shape of a posture check. exactly one of OperatingSystems,
+// MacAddresses, Processes and MFA is set and decides the type of the check
+type PostureCheckSpec struct {
+	Name           string
+	RoleAttributes []string
+	// OperatingSystems lists the operating systems, and optionally versions, a client must run
+	OperatingSystems []*rest_model.OperatingSystem
+	// MacAddresses lists the MAC addresses of which a client must have at least one
+	MacAddresses []string
+	// Processes lists processes of which a client must be running at least one
+	Processes []*rest_model.ProcessMulti
+	// MFA requires the client to have completed multi-factor authentication
+	MFA *rest_model.PostureCheckMfaProperties
+}
+
+// postureCheckFields are the fields shared by every posture check create and update
+type postureCheckFields interface {
+	SetName(*string)
+	SetRoleAttributes(*rest_model.Attributes)
+	SetTags(*rest_model.Tags)
+}
+
+func (spec PostureCheckSpec) typeID() (rest_model.PostureCheckType, error) {
+	var types []rest_model.PostureCheckType
+	if spec.OperatingSystems != nil {
+		types = append(types, rest_model.PostureCheckTypeOS)
+	}
+	if spec.MacAddresses != nil {
+		types = append(types, rest_model.PostureCheckTypeMAC)
+	}
+	if spec.Processes != nil {
+		types = append(types, rest_model.PostureCheckTypePROCESSMULTI)
+	}
+	if spec.MFA != nil {
+		types = append(types, rest_model.PostureCheckTypeMFA)
+	}
+	if len(types) != 1 {
+		return "", errors.New("posture check " + spec.Name + " needs exactly one of operating systems, mac addresses, processes or mfa")
+	}
+	return types[0], nil
+}
+
+func (spec PostureCheckSpec) create(tags *rest_model.Tags) rest_model.PostureCheckCreate {
+	var create rest_model.PostureCheckCreate
+	anyOf := rest_model.SemanticAnyOf
+	switch {
+	case spec.OperatingSystems != nil:
+		create = &rest_model.PostureCheckOperatingSystemCreate{OperatingSystems: spec.OperatingSystems}
+	case spec.MacAddresses != nil:
+		create = &rest_model.PostureCheckMacAddressCreate{MacAddresses: spec.MacAddresses}
+	case spec.Processes != nil:
+		create = &rest_model.PostureCheckProcessMultiCreate{Processes: spec.Processes, Semantic: &anyOf}
+	default:
+		create = &rest_model.PostureCheckMfaCreate{PostureCheckMfaProperties: *spec.MFA}
+	}
+	spec.setFields(create, tags)
+	return create
+}
+
+func (spec PostureCheckSpec) update(tags *rest_model.Tags) rest_model.PostureCheckUpdate {
+	var update rest_model.PostureCheckUpdate
+	anyOf := rest_model.SemanticAnyOf
+	switch {
+	case spec.OperatingSystems != nil:
+		update = &rest_model.PostureCheckOperatingSystemUpdate{OperatingSystems: spec.OperatingSystems}
+	case spec.MacAddresses != nil:
+		update = &rest_model.PostureCheckMacAddressUpdate{MacAddresses: spec.MacAddresses}
+	case spec.Processes != nil:
+		update = &rest_model.PostureCheckProcessMultiUpdate{Processes: spec.Processes, Semantic: &anyOf}
+	default:
+		update = &rest_model.PostureCheckMfaUpdate{PostureCheckMfaProperties: *spec.MFA}
+	}
+	spec.setFields(update, tags)
+	return update
+}
+
+func (spec PostureCheckSpec) setFields(f postureCheckFields, tags *rest_model.Tags) {
+	name := spec.Name
+	attrs := rest_model.Attributes(spec.RoleAttributes)
+	f.SetName(&name)
+	f.SetRoleAttributes(&attrs)
+	f.SetTags(tags)
+}
+
+func (c *Client) planPostureChecks(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.PostureChecks {
+		wanted[spec.Name] = true
+		typeID, err := spec.typeID()
+		if err != nil {
+			return nil, err
+		}
+		existing, err := c.api.ListPostureChecks(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list posture checks named %s: %w", spec.Name, err)
+		}
+		// the type of a posture check can't be changed, so it is replaced. policies refer to posture
+		// checks by role, so nothing else has to change
+		if len(existing) > 0 && (desired.Recreate || existing[0].TypeID() != string(typeID)) {
+			steps = append(steps, c.deletePostureCheckStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "posture check", Name: name, Detail: string(typeID)},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreatePostureCheck(ctx, spec.create(desired.Origin.tags()))
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if !sameStrings(attributes(actual.RoleAttributes()), spec.RoleAttributes) {
+			drift = append(drift, fmt.Sprintf("roleAttributes %v -> %v", attributes(actual.RoleAttributes()), spec.RoleAttributes))
+		}
+		if changed, err := postureCheckDrift(actual, spec.create(nil)); err != nil {
+			return nil, fmt.Errorf("could not compare posture check %s: %w", name, err)
+		} else if len(changed) > 0 {
+			drift = append(drift, changed...)
+		}
+		if !desired.Origin.owns(actual.Tags()) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID()
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "posture check", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.UpdatePostureCheck(ctx, id, spec.update(desired.Origin.retag(actual.Tags())))
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedPostureChecks(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scoped {
+		if !wanted[*p.Name()] {
+			steps = append(steps, c.deletePostureCheckStep(p))
+		}
+	}
+	return steps, nil
+}
+
+// postureCheckCommon are the json fields every posture check has. the rest describe the check itself
+var postureCheckCommon = map[string]bool{
+	"id": true, "name": true, "tags": true, "roleAttributes": true, "typeId": true, "createdAt": true,
+	"updatedAt": true, "_links": true, "version": true,
+}
+
+// postureCheckDrift lists the check specific json fields that differ between the actual and desired check
+func postureCheckDrift(actual rest_model.PostureCheckDetail, desired rest_model.PostureCheckCreate) ([]string, error) {
+	actualFields, err := jsonFields(actual)
+	if err != nil {
+		return nil, err
+	}
+	desiredFields, err := jsonFields(desired)
+	if err != nil {
+		return nil, err
+	}
+	var drift []string
+	for k := range desiredFields {
+		if !postureCheckCommon[k] && !reflect.DeepEqual(actualFields[k], desiredFields[k]) {
+			drift = append(drift, k)
+		}
+	}
+	for k := range actualFields {
+		if _, found := desiredFields[k]; !found && !postureCheckCommon[k] {
+			drift = append(drift, k)
+		}
+	}
+	slices.Sort(drift)
+	return drift, nil
+}
+
+// jsonFields returns the json fields of v without empty values, which the controller may omit
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return compact(fields).(map[string]interface{}), nil
+}
+
+// compact drops empty values from json maps, recursively
+func compact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if e = compact(e); isEmpty(e) {
+				delete(t, k)
+			} else {
+				t[k] = e
+			}
+		}
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = compact(e)
+		}
+		return t
+	}
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !t
+	case float64:
+		return t == 0
+	case string:
+		return t == ""
+	case []interface{}:
+		return len(t) == 0
+	case map[string]interface{}:
+		return len(t) == 0
+	}
+	return false
+}
+
+// scopedPostureChecks lists the posture checks tagged with the origin and the untagged checks whose names start with prefix
+func (c *Client) scopedPostureChecks(ctx context.Context, origin Origin, prefix string) ([]rest_model.PostureCheckDetail, error) {
+	var result []rest_model.PostureCheckDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListPostureChecks(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list posture checks of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListPostureChecks(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list posture checks for %s: %w", prefix, err)
+		}
+		for _, p := range named {
+			if isLegacy(*p.Name(), p.Tags(), prefix) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deletePostureCheckStep(p rest_model.PostureCheckDetail) step {
+	id := *p.ID()
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "posture check", Name: *p.Name()},
+		apply: func(ctx context.Context) error {
+			return c.api.DeletePostureCheck(ctx, id)
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package manage_test
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"slices"
+	"testing"
+)
+
+func checked(systems ...rest_model.OsType) manage.DesiredState {
+	var operatingSystems []*rest_model.OperatingSystem
+	for _, t := range systems {
+		osType := t
+		operatingSystems = append(operatingSystems, &rest_model.OperatingSystem{Type: &osType, Versions: []string{}})
+	}
+	desired := demo()
+	desired.PostureChecks = []manage.PostureCheckSpec{
+		{Name: "test_os", RoleAttributes: []string{"test_checks"}, OperatingSystems: operatingSystems},
+		{Name: "test_mfa", RoleAttributes: []string{"test_checks"}, MFA: &rest_model.PostureCheckMfaProperties{}},
+	}
+	desired.ServicePolicies[0].PostureCheckRoles = []string{"#test_checks"}
+	return desired
+}
+
+func TestReconcilePostureChecks(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, checked(rest_model.OsTypeLinux)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.PostureChecks()) != 2 {
+		t.Fatalf("created %d posture checks", len(ctrl.PostureChecks()))
+	}
+	if roles := ctrl.ServicePolicies()[0].PostureCheckRoles; !slices.Equal(roles, []string{"#test_checks"}) {
+		t.Errorf("the dial policy requires the posture checks %v", roles)
+	}
+
+	report, err := c.Reconcile(ctx, checked(rest_model.OsTypeLinux))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("reconciling again made %v", changed(report))
+	}
+
+	report, err = c.Reconcile(ctx, checked(rest_model.OsTypeLinux, rest_model.OsTypeMacOS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Equal(got, []string{"update posture check test_os"}) {
+		t.Errorf("allowing another operating system made %v", got)
+	}
+
+	desired := checked(rest_model.OsTypeLinux)
+	desired.PostureChecks = desired.PostureChecks[:1]
+	report, err = c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Contains(got, "delete posture check test_mfa") {
+		t.Errorf("removing the mfa check made %v", got)
+	}
+}
+
+func TestPostureCheckNeedsOneKind(t *testing.T) {
+	c, _ := managetest.NewClient()
+	desired := demo()
+	desired.PostureChecks = []manage.PostureCheckSpec{{Name: "test_nothing"}}
+	if _, err := c.Reconcile(context.Background(), desired); err == nil {
+		t.Error("a posture check without any requirement was accepted")
+	}
+}
+
+func TestTeardownPostureChecks(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, checked(rest_model.OsTypeWindows)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Teardown(ctx, "test", "test_", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.PostureChecks()) != 0 {
+		t.Error("the teardown left posture checks")
+	}
+}
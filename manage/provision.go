@@ -0,0 +1,68 @@
+package manage
+
+import (
+	"context"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+)
+
+// ProvisionStatus says what provisioning did with an identity
+type ProvisionStatus string
+
+const (
+	// ProvisionCreated is an identity created by this provisioning run
+	ProvisionCreated ProvisionStatus = "created"
+	// ProvisionPending is an identity provisioned earlier that has yet to enroll. its token is returned again
+	ProvisionPending ProvisionStatus = "pending"
+	// ProvisionEnrolled is an identity provisioned earlier that has already enrolled. it has no token left
+	ProvisionEnrolled ProvisionStatus = "enrolled"
+)
+
+// ProvisionedIdentity is an identity created in bulk and the token it enrolls with
+type ProvisionedIdentity struct {
+	Name   string
+	Status ProvisionStatus
+	Method EnrollmentMethod
+	JWT    string
+}
+
+// ProvisionIdentities makes sure an identity tagged with the origin exists for every name. identities the
+// origin provisioned before are kept, so a list can be provisioned again without invalidating the tokens
+// already handed out. it stops at the first name taken by an identity the origin did not create, returning
+// what was provisioned until then
+func (c *Client) ProvisionIdentities(ctx context.Context, origin Origin, names []string, attributes *rest_model.Attributes, enrollment Enrollment) ([]ProvisionedIdentity, error) {
+	result := make([]ProvisionedIdentity, 0, len(names))
+	for _, name := range names {
+		p, err := c.provisionIdentity(ctx, origin, name, attributes, enrollment)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+func (c *Client) provisionIdentity(ctx context.Context, origin Origin, name string, attributes *rest_model.Attributes, enrollment Enrollment) (ProvisionedIdentity, error) {
+	existing, err := c.api.ListIdentities(ctx, nameFilter(name))
+	if err != nil {
+		return ProvisionedIdentity{}, fmt.Errorf("could not list identities named %s: %w", name, err)
+	}
+	if len(existing) > 0 {
+		i := existing[0]
+		if !origin.owns(i.Tags) {
+			return ProvisionedIdentity{}, fmt.Errorf("identity %s was not provisioned by %s: %w", name, origin.Instance, ErrConflict)
+		}
+		method, jwt, pending := PendingEnrollment(i)
+		if !pending {
+			return ProvisionedIdentity{Name: name, Status: ProvisionEnrolled}, nil
+		}
+		return ProvisionedIdentity{Name: name, Status: ProvisionPending, Method: method, JWT: jwt}, nil
+	}
+
+	created, err := c.CreateIdentity(ctx, origin, rest_model.IdentityTypeUser, name, attributes, enrollment)
+	if err != nil {
+		return ProvisionedIdentity{}, err
+	}
+	method, jwt, _ := PendingEnrollment(created)
+	return ProvisionedIdentity{Name: name, Status: ProvisionCreated, Method: method, JWT: jwt}, nil
+}
@@ -0,0 +1,756 @@
+package manage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ServiceSpec is the desired shape of a service
+type ServiceSpec struct {
+	Name               string
+	RoleAttributes     []string
+	EncryptionRequired bool
+	// Configs names the configs attached to the service. they must be desired configs or already exist
+	Configs []string
+}
+
+// ConfigSpec is the desired shape of a config, such as the intercept.v1 config that lets tunnelers
+// reach a service by a hostname
+type ConfigSpec struct {
+	Name string
+	// Type is the name of the config type, e.g. intercept.v1 or host.v1
+	Type string
+	Data interface{}
+}
+
+// ServicePolicySpec is the desired shape of a service policy
+type ServicePolicySpec struct {
+	Name          string
+	Type          rest_model.DialBind
+	Semantic      rest_model.Semantic
+	IdentityRoles []string
+	ServiceRoles  []string
+	// PostureCheckRoles are the posture checks an identity must pass to use the services
+	PostureCheckRoles []string
+}
+
+// IdentitySpec is the desired shape of an identity. identities are created with a one-time token
+// enrollment
+type IdentitySpec struct {
+	Name           string
+	Type           rest_model.IdentityType
+	RoleAttributes []string
+	// Recreate deletes and recreates the identity if it exists, issuing a new enrollment
+	Recreate bool
+}
+
+// DesiredState is everything a demo instance expects to find on the controller
+type DesiredState struct {
+	// Origin tags every object the reconciler creates or updates
+	Origin Origin
+	// services and service policies that are not desired are pruned when they are tagged with
+	// Origin, or carry no appetizer tags and have a name starting with Prefix, unless another
+	// underscore follows the prefix. untagged objects are left alone when Prefix is empty
+	Prefix string
+	// Recreate deletes and recreates every desired service and service policy that exists instead
+	// of updating it. configs are always updated in place since services may still reference them
+	Recreate        bool
+	Configs         []ConfigSpec
+	Services        []ServiceSpec
+	ServicePolicies []ServicePolicySpec
+	Identities      []IdentitySpec
+
+	EdgeRouterPolicies        []EdgeRouterPolicySpec
+	ServiceEdgeRouterPolicies []ServiceEdgeRouterPolicySpec
+	PostureChecks             []PostureCheckSpec
+	ExternalJWTSigners        []ExternalJWTSignerSpec
+	AuthPolicies              []AuthPolicySpec
+}
+
+type ChangeAction string
+
+const (
+	ActionCreate ChangeAction = "create"
+	ActionUpdate ChangeAction = "update"
+	ActionDelete ChangeAction = "delete"
+)
+
+// Change is a single mutation made, or to be made, to bring the controller to the desired state
+type Change struct {
+	Action ChangeAction
+	Kind   string
+	Name   string
+	// Detail describes what drifted for updates
+	Detail string
+}
+
+func (ch Change) String() string {
+	s := fmt.Sprintf("%s %s %s", ch.Action, ch.Kind, ch.Name)
+	if ch.Detail != "" {
+		s += " (" + ch.Detail + ")"
+	}
+	return s
+}
+
+// Report lists the changes a reconciliation made or, from Plan, would make
+type Report struct {
+	Changes []Change
+}
+
+func (r Report) String() string {
+	if len(r.Changes) == 0 {
+		return "no changes"
+	}
+	lines := make([]string, 0, len(r.Changes))
+	for _, ch := range r.Changes {
+		lines = append(lines, ch.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diff renders the report one change per line, marked with + for creates, ~ for updates and - for deletes
+func (r Report) Diff() string {
+	if len(r.Changes) == 0 {
+		return "no changes"
+	}
+	lines := make([]string, 0, len(r.Changes))
+	for _, ch := range r.Changes {
+		mark := "~"
+		switch ch.Action {
+		case ActionCreate:
+			mark = "+"
+		case ActionDelete:
+			mark = "-"
+		}
+		lines = append(lines, mark+" "+ch.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// step is a planned change and the call that makes it
+type step struct {
+	Change
+	apply func(ctx context.Context) error
+}
+
+// Reconcile compares the desired state with the controller and creates, updates or deletes only
+// the objects that drifted. the report lists the changes applied before any error occurred
+func (c *Client) Reconcile(ctx context.Context, desired DesiredState) (Report, error) {
+	steps, err := c.plan(ctx, desired)
+	if err != nil {
+		return Report{}, err
+	}
+	return c.apply(ctx, desired.Origin.Instance, steps)
+}
+
+// apply makes each change in order, recording it to the audit log, and stops at the first failure
+func (c *Client) apply(ctx context.Context, instance string, steps []step) (Report, error) {
+	report := Report{}
+	for _, s := range steps {
+		err := s.apply(ctx)
+		c.audit(ctx, instance, s.Change, "", err)
+		if err != nil {
+			return report, fmt.Errorf("could not %s: %w", s.Change, err)
+		}
+		report.Changes = append(report.Changes, s.Change)
+	}
+	return report, nil
+}
+
+func changes(steps []step) Report {
+	report := Report{}
+	for _, s := range steps {
+		report.Changes = append(report.Changes, s.Change)
+	}
+	return report
+}
+
+// Plan reports the changes Reconcile would make for the desired state without changing anything
+func (c *Client) Plan(ctx context.Context, desired DesiredState) (Report, error) {
+	steps, err := c.plan(ctx, desired)
+	if err != nil {
+		return Report{}, err
+	}
+	return changes(steps), nil
+}
+
+func (c *Client) plan(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+
+	policySteps, err := c.planServicePolicies(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	configSteps, err := c.planConfigs(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	serviceSteps, err := c.planServices(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	identitySteps, err := c.planIdentities(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	erpSteps, err := c.planEdgeRouterPolicies(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	serpSteps, err := c.planServiceEdgeRouterPolicies(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	postureSteps, err := c.planPostureChecks(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	signerSteps, err := c.planExternalJWTSigners(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	authPolicySteps, err := c.planAuthPolicies(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	// policies are pruned before the services they may reference and created after them. configs are
+	// created before the services that use them and pruned after
+	for _, s := range policySteps {
+		if s.Action == ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	for _, s := range configSteps {
+		if s.Action != ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	steps = append(steps, serviceSteps...)
+	for _, s := range configSteps {
+		if s.Action == ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	for _, s := range policySteps {
+		if s.Action != ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	// router policies and posture checks only match by role, so they don't depend on the objects around them
+	steps = append(steps, postureSteps...)
+	steps = append(steps, serpSteps...)
+	steps = append(steps, erpSteps...)
+	// auth policies refer to signers and are referred to by identities, so they are created from the
+	// signer up and pruned from the identity down
+	for _, s := range append(signerSteps, authPolicySteps...) {
+		if s.Action != ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	steps = append(steps, identitySteps...)
+	for _, s := range append(authPolicySteps, signerSteps...) {
+		if s.Action == ActionDelete {
+			steps = append(steps, s)
+		}
+	}
+	return steps, nil
+}
+
+func (c *Client) planServices(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.Services {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListServices(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list services named %s: %w", spec.Name, err)
+		}
+		if len(existing) > 0 && desired.Recreate {
+			steps = append(steps, c.deleteServiceStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "service", Name: name},
+				apply: func(ctx context.Context) error {
+					configs, err := c.configIDs(ctx, spec.Configs)
+					if err != nil {
+						return err
+					}
+					_, err = c.api.CreateService(ctx, &rest_model.ServiceCreate{
+						Name:               &name,
+						EncryptionRequired: &spec.EncryptionRequired,
+						RoleAttributes:     spec.RoleAttributes,
+						Configs:            configs,
+						Tags:               desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if !sameStrings(attributes(actual.RoleAttributes), spec.RoleAttributes) {
+			drift = append(drift, fmt.Sprintf("roleAttributes %v -> %v", attributes(actual.RoleAttributes), spec.RoleAttributes))
+		}
+		if actual.EncryptionRequired == nil || *actual.EncryptionRequired != spec.EncryptionRequired {
+			drift = append(drift, fmt.Sprintf("encryptionRequired -> %t", spec.EncryptionRequired))
+		}
+		// configs that don't exist yet are created by an earlier step, so their ids can't match
+		if ids, err := c.configIDs(ctx, spec.Configs); err != nil || !sameStrings(actual.Configs, ids) {
+			drift = append(drift, fmt.Sprintf("configs -> %v", spec.Configs))
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "service", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					configs, err := c.configIDs(ctx, spec.Configs)
+					if err != nil {
+						return err
+					}
+					return c.api.UpdateService(ctx, id, &rest_model.ServiceUpdate{
+						Name:               &name,
+						EncryptionRequired: spec.EncryptionRequired,
+						RoleAttributes:     spec.RoleAttributes,
+						Configs:            configs,
+						Tags:               desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedServices(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scoped {
+		if !wanted[*s.Name] {
+			steps = append(steps, c.deleteServiceStep(s))
+		}
+	}
+	return steps, nil
+}
+
+// scopedServices lists the services tagged with the origin and the untagged services whose names start with prefix
+func (c *Client) scopedServices(ctx context.Context, origin Origin, prefix string) ([]*rest_model.ServiceDetail, error) {
+	var result []*rest_model.ServiceDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListServices(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list services of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListServices(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list services for %s: %w", prefix, err)
+		}
+		for _, s := range named {
+			if isLegacy(*s.Name, s.Tags, prefix) {
+				result = append(result, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteServiceStep(s *rest_model.ServiceDetail) step {
+	id := *s.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "service", Name: *s.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteService(ctx, id)
+		},
+	}
+}
+
+// configIDs looks up the ids of the named configs
+func (c *Client) configIDs(ctx context.Context, names []string) ([]string, error) {
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		existing, err := c.api.ListConfigs(ctx, nameFilter(name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list configs named %s: %w", name, err)
+		}
+		if len(existing) == 0 {
+			return nil, fmt.Errorf("config %s: %w", name, ErrNotFound)
+		}
+		ids = append(ids, *existing[0].ID)
+	}
+	return ids, nil
+}
+
+func (c *Client) planConfigs(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.Configs {
+		wanted[spec.Name] = true
+		types, err := c.api.ListConfigTypes(ctx, nameFilter(spec.Type))
+		if err != nil {
+			return nil, fmt.Errorf("could not list config types named %s: %w", spec.Type, err)
+		}
+		if len(types) == 0 {
+			return nil, fmt.Errorf("config type %s: %w", spec.Type, ErrNotFound)
+		}
+		typeID := *types[0].ID
+		data, err := normalize(spec.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data for config %s: %w", spec.Name, err)
+		}
+
+		existing, err := c.api.ListConfigs(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list configs named %s: %w", spec.Name, err)
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "config", Name: name, Detail: spec.Type},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreateConfig(ctx, &rest_model.ConfigCreate{
+						Name:         &name,
+						ConfigTypeID: &typeID,
+						Data:         data,
+						Tags:         desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		if actual.ConfigTypeID == nil || *actual.ConfigTypeID != typeID {
+			return nil, fmt.Errorf("config %s exists with a different config type than %s. delete it to let it be recreated", name, spec.Type)
+		}
+		var drift []string
+		if actualData, err := normalize(actual.Data); err != nil || !reflect.DeepEqual(actualData, data) {
+			drift = append(drift, "data")
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "config", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.UpdateConfig(ctx, id, &rest_model.ConfigUpdate{
+						Name: &name,
+						Data: data,
+						Tags: desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedConfigs(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range scoped {
+		if !wanted[*cfg.Name] {
+			steps = append(steps, c.deleteConfigStep(cfg))
+		}
+	}
+	return steps, nil
+}
+
+// scopedConfigs lists the configs tagged with the origin and the untagged configs whose names start with prefix
+func (c *Client) scopedConfigs(ctx context.Context, origin Origin, prefix string) ([]*rest_model.ConfigDetail, error) {
+	var result []*rest_model.ConfigDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListConfigs(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list configs of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListConfigs(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list configs for %s: %w", prefix, err)
+		}
+		for _, cfg := range named {
+			if isLegacy(*cfg.Name, cfg.Tags, prefix) {
+				result = append(result, cfg)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteConfigStep(cfg *rest_model.ConfigDetail) step {
+	id := *cfg.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "config", Name: *cfg.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteConfig(ctx, id)
+		},
+	}
+}
+
+// normalize round-trips config data through JSON so desired data compares equal to what the controller returns
+func normalize(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	return result, json.Unmarshal(b, &result)
+}
+
+func (c *Client) planServicePolicies(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.ServicePolicies {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListServicePolicies(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list service policies named %s: %w", spec.Name, err)
+		}
+		if len(existing) > 0 && desired.Recreate {
+			steps = append(steps, c.deleteServicePolicyStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "service policy", Name: name},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreateServicePolicy(ctx, &rest_model.ServicePolicyCreate{
+						Name:              &name,
+						Type:              &spec.Type,
+						Semantic:          &spec.Semantic,
+						IdentityRoles:     spec.IdentityRoles,
+						ServiceRoles:      spec.ServiceRoles,
+						PostureCheckRoles: spec.PostureCheckRoles,
+						Tags:              desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if actual.Type == nil || *actual.Type != spec.Type {
+			drift = append(drift, fmt.Sprintf("type -> %s", spec.Type))
+		}
+		if actual.Semantic == nil || *actual.Semantic != spec.Semantic {
+			drift = append(drift, fmt.Sprintf("semantic -> %s", spec.Semantic))
+		}
+		if !sameStrings(actual.IdentityRoles, spec.IdentityRoles) {
+			drift = append(drift, fmt.Sprintf("identityRoles %v -> %v", []string(actual.IdentityRoles), spec.IdentityRoles))
+		}
+		if !sameStrings(actual.ServiceRoles, spec.ServiceRoles) {
+			drift = append(drift, fmt.Sprintf("serviceRoles %v -> %v", []string(actual.ServiceRoles), spec.ServiceRoles))
+		}
+		if !sameStrings(actual.PostureCheckRoles, spec.PostureCheckRoles) {
+			drift = append(drift, fmt.Sprintf("postureCheckRoles %v -> %v", []string(actual.PostureCheckRoles), spec.PostureCheckRoles))
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "service policy", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.UpdateServicePolicy(ctx, id, &rest_model.ServicePolicyUpdate{
+						Name:              &name,
+						Type:              &spec.Type,
+						Semantic:          &spec.Semantic,
+						IdentityRoles:     spec.IdentityRoles,
+						ServiceRoles:      spec.ServiceRoles,
+						PostureCheckRoles: spec.PostureCheckRoles,
+						Tags:              desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedServicePolicies(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scoped {
+		if !wanted[*p.Name] {
+			steps = append(steps, c.deleteServicePolicyStep(p))
+		}
+	}
+	return steps, nil
+}
+
+// scopedServicePolicies lists the service policies tagged with the origin and the untagged policies whose names start with prefix
+func (c *Client) scopedServicePolicies(ctx context.Context, origin Origin, prefix string) ([]*rest_model.ServicePolicyDetail, error) {
+	var result []*rest_model.ServicePolicyDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListServicePolicies(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list service policies of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListServicePolicies(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list service policies for %s: %w", prefix, err)
+		}
+		for _, p := range named {
+			if isLegacy(*p.Name, p.Tags, prefix) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteServicePolicyStep(p *rest_model.ServicePolicyDetail) step {
+	id := *p.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "service policy", Name: *p.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteServicePolicy(ctx, id)
+		},
+	}
+}
+
+func (c *Client) planIdentities(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	for _, spec := range desired.Identities {
+		existing, err := c.api.ListIdentities(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list identities named %s: %w", spec.Name, err)
+		}
+		if len(existing) > 0 && spec.Recreate {
+			steps = append(steps, c.deleteIdentityStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		attrs := rest_model.Attributes(spec.RoleAttributes)
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "identity", Name: name},
+				apply: func(ctx context.Context) error {
+					isAdmin := false
+					_, err := c.api.CreateIdentity(ctx, &rest_model.IdentityCreate{
+						Name:           &name,
+						Type:           &spec.Type,
+						IsAdmin:        &isAdmin,
+						RoleAttributes: &attrs,
+						Enrollment: &rest_model.IdentityCreateEnrollment{
+							Ott: true,
+						},
+						Tags: desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if actual.Type == nil || actual.Type.Name != string(spec.Type) {
+			drift = append(drift, fmt.Sprintf("type -> %s", spec.Type))
+		}
+		if !sameStrings(attributes(actual.RoleAttributes), spec.RoleAttributes) {
+			drift = append(drift, fmt.Sprintf("roleAttributes %v -> %v", attributes(actual.RoleAttributes), spec.RoleAttributes))
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "identity", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.PatchIdentity(ctx, id, &rest_model.IdentityPatch{
+						Type:           spec.Type,
+						RoleAttributes: &attrs,
+						Tags:           desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+	return steps, nil
+}
+
+// inScope reports whether the name carries the instance's name prefix. instance foo can't tell its
+// object bar_x from object x of instance foo_bar, so names with an underscore after the prefix are left alone
+func inScope(name string, prefix string) bool {
+	rest, found := strings.CutPrefix(name, prefix)
+	return found && rest != "" && !strings.Contains(rest, "_")
+}
+
+func (c *Client) deleteIdentityStep(i *rest_model.IdentityDetail) step {
+	id := *i.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "identity", Name: *i.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteIdentity(ctx, id)
+		},
+	}
+}
+
+// isLegacy reports whether an object predates tagging and belongs to the instance with the given name prefix.
+// untagged names with another underscore after the prefix could belong to another instance, so they aren't
+// legacy. identities also own generated visitor names, see ownedBy
+func isLegacy(name string, tags *rest_model.Tags, prefix string) bool {
+	return tagString(tags, TagInstance) == "" && inScope(name, prefix)
+}
+
+func nameFilter(name string) string {
+	return "name=" + quoted(name)
+}
+
+func containsFilter(s string) string {
+	return "name contains " + quoted(s)
+}
+
+var filterEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoted returns s as a string of the controller's filter language. quotes and backslashes are escaped
+// so a name can't change what the filter matches
+func quoted(s string) string {
+	return `"` + filterEscaper.Replace(s) + `"`
+}
+
+func attributes(a *rest_model.Attributes) []string {
+	if a == nil {
+		return nil
+	}
+	return *a
+}
+
+// sameStrings reports whether both slices hold the same values, ignoring order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sa := slices.Clone(a)
+	sb := slices.Clone(b)
+	slices.Sort(sa)
+	slices.Sort(sb)
+	return slices.Equal(sa, sb)
+}
@@ -0,0 +1,269 @@
+package manage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var network = manage.Origin{Instance: "test", Component: manage.ComponentNetwork}
+
+// demo is a small instance: a service with an intercept config, the policy to dial it and the server identity
+func demo() manage.DesiredState {
+	return manage.DesiredState{
+		Origin: network,
+		Prefix: "test_",
+		Configs: []manage.ConfigSpec{
+			{Name: "test_intercept", Type: "intercept.v1", Data: map[string]interface{}{
+				"protocols": []string{"tcp"},
+				"addresses": []string{"test.appetizer.ziti"},
+			}},
+		},
+		Services: []manage.ServiceSpec{
+			{Name: "test_httpService", RoleAttributes: []string{"test_demo-services"}, EncryptionRequired: true, Configs: []string{"test_intercept"}},
+		},
+		ServicePolicies: []manage.ServicePolicySpec{
+			{Name: "test_dial", Type: rest_model.DialBindDial, Semantic: rest_model.SemanticAllOf,
+				IdentityRoles: []string{"#test_demo.clients"}, ServiceRoles: []string{"#test_demo-services"}},
+		},
+		Identities: []manage.IdentitySpec{
+			{Name: "test_server", Type: rest_model.IdentityTypeDevice, RoleAttributes: []string{"test_demo.servers"}},
+		},
+	}
+}
+
+func changed(report manage.Report) []string {
+	var result []string
+	for _, ch := range report.Changes {
+		result = append(result, string(ch.Action)+" "+ch.Kind+" "+ch.Name)
+	}
+	return result
+}
+
+func serviceNames(ctrl *managetest.Controller) []string {
+	var names []string
+	for _, s := range ctrl.Services() {
+		names = append(names, *s.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestReconcileIsIdempotent(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+
+	report, err := c.Reconcile(ctx, demo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 4 {
+		t.Errorf("the first reconcile made %v, expected every object to be created", changed(report))
+	}
+	services := ctrl.Services()
+	if len(services) != 1 || len(services[0].Configs) != 1 {
+		t.Fatalf("expected the service with its config, found %v", services)
+	}
+
+	plan, err := c.Plan(ctx, demo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Errorf("planned %v for a network that is up to date", changed(plan))
+	}
+	report, err = c.Reconcile(ctx, demo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changes) != 0 {
+		t.Errorf("reconciling again made %v", changed(report))
+	}
+}
+
+func TestReconcileRecreate(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+	before := *ctrl.Services()[0].ID
+
+	desired := demo()
+	desired.Recreate = true
+	report, err := c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// configs are updated in place since services may still reference them
+	want := []string{
+		"delete service policy test_dial",
+		"delete service test_httpService",
+		"create service test_httpService",
+		"create service policy test_dial",
+	}
+	if got := changed(report); !slices.Equal(got, want) {
+		t.Errorf("recreating made %v, expected %v", got, want)
+	}
+	if *ctrl.Services()[0].ID == before {
+		t.Error("the service was not recreated")
+	}
+}
+
+func TestReconcileUpdatesDrift(t *testing.T) {
+	c, _ := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := demo()
+	desired.Services[0].RoleAttributes = []string{"test_other-services"}
+	report, err := c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Equal(got, []string{"update service test_httpService"}) {
+		t.Errorf("changing the service's attributes made %v", got)
+	}
+}
+
+func TestReconcilePrunesOnlyItsOwnObjects(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+	other := manage.DesiredState{
+		Origin:   manage.Origin{Instance: "test_b", Component: manage.ComponentNetwork},
+		Services: []manage.ServiceSpec{{Name: "test_b_httpService", EncryptionRequired: true}},
+	}
+	if _, err := c.Reconcile(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	// untagged services made before objects were tagged. test_b_legacy could belong to instance test_b
+	encrypted := true
+	for _, name := range []string{"test_legacy", "test_b_legacy", "unrelated"} {
+		if _, err := ctrl.CreateService(ctx, &rest_model.ServiceCreate{Name: &name, EncryptionRequired: &encrypted}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	desired := demo()
+	desired.Services = nil
+	desired.Configs = nil
+	report, err := c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left, want := serviceNames(ctrl), []string{"test_b_httpService", "test_b_legacy", "unrelated"}; !slices.Equal(left, want) {
+		t.Errorf("after pruning %v, %v are left, expected %v", changed(report), left, want)
+	}
+	if len(ctrl.Configs()) != 0 {
+		t.Errorf("the config that is no longer desired was kept")
+	}
+}
+
+func TestReconcileUpdatesConfigData(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := demo()
+	desired.Configs[0].Data = map[string]interface{}{
+		"protocols": []string{"tcp"},
+		"addresses": []string{"other.appetizer.ziti"},
+	}
+	report, err := c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Equal(got, []string{"update config test_intercept"}) {
+		t.Errorf("changing the intercept address made %v", got)
+	}
+	if cfg := ctrl.Configs()[0]; !strings.Contains(fmt.Sprint(cfg.Data), "other.appetizer.ziti") {
+		t.Errorf("the config holds %v", cfg.Data)
+	}
+}
+
+func TestReconcileNamesNeedingEscapes(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	desired := manage.DesiredState{
+		Origin:   network,
+		Services: []manage.ServiceSpec{{Name: `test_a "quoted" \ name`, EncryptionRequired: true}},
+	}
+	for range 2 {
+		if _, err := c.Reconcile(ctx, desired); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ctrl.Services()) != 1 {
+		t.Errorf("expected the service to be found again instead of created twice, found %d", len(ctrl.Services()))
+	}
+}
+
+func TestDeleteOwnedIdentity(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	visitors := manage.Origin{Instance: "test", Component: manage.ComponentVisitor}
+	workshop := manage.Origin{Instance: "test", Component: manage.ComponentWorkshop}
+	enrollment := manage.Enrollment{Method: manage.EnrollmentOTT}
+	if _, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_amy", nil, enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIdentity(ctx, workshop, rest_model.IdentityTypeUser, "test_bob", nil, enrollment); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteOwnedIdentity(ctx, visitors, "test_bob"); !errors.Is(err, manage.ErrConflict) {
+		t.Errorf("deleting a workshop identity as a visitor returned %v, expected a conflict", err)
+	}
+	if err := c.DeleteOwnedIdentity(ctx, visitors, "test_amy"); err != nil {
+		t.Error(err)
+	}
+	if err := c.DeleteOwnedIdentity(ctx, visitors, "test_nobody"); err != nil {
+		t.Errorf("deleting a missing identity returned %v", err)
+	}
+	if ids := ctrl.Identities(); len(ids) != 1 || *ids[0].Name != "test_bob" {
+		t.Errorf("expected only the workshop identity to be left")
+	}
+}
+
+func TestDeleteIdentityByExternalID(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	visitors := manage.Origin{Instance: "test", Component: manage.ComponentVisitor}
+	others := manage.Origin{Instance: "test_b", Component: manage.ComponentVisitor}
+	create := func(origin manage.Origin, name string, externalID string) {
+		t.Helper()
+		enrollment := manage.Enrollment{Method: manage.EnrollmentOTT, ExternalID: externalID}
+		if _, err := c.CreateIdentity(ctx, origin, rest_model.IdentityTypeUser, name, nil, enrollment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create(visitors, "test_amy", "amy")
+	create(others, "test_b_bob", "bob")
+	create(visitors, "test_quote", `" or externalId contains "`)
+
+	if err := c.DeleteIdentityByExternalID(ctx, visitors, "bob"); !errors.Is(err, manage.ErrConflict) {
+		t.Errorf("replacing another instance's identity returned %v, expected a conflict", err)
+	}
+	if err := c.DeleteIdentityByExternalID(ctx, visitors, `" or externalId contains "`); err != nil {
+		t.Error(err)
+	}
+	if err := c.DeleteIdentityByExternalID(ctx, visitors, "amy"); err != nil {
+		t.Error(err)
+	}
+	if ids := ctrl.Identities(); len(ids) != 1 || *ids[0].Name != "test_b_bob" {
+		t.Errorf("expected only the other instance's identity to be left")
+	}
+}
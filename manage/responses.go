@@ -0,0 +1,162 @@
+package manage
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/openziti/edge-api/rest_management_api_client/auth_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/config"
+	"github.com/openziti/edge-api/rest_management_api_client/edge_router_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/external_jwt_signer"
+	"github.com/openziti/edge-api/rest_management_api_client/identity"
+	"github.com/openziti/edge-api/rest_management_api_client/posture_checks"
+	"github.com/openziti/edge-api/rest_management_api_client/service"
+	"github.com/openziti/edge-api/rest_management_api_client/service_edge_router_policy"
+	"github.com/openziti/edge-api/rest_management_api_client/service_policy"
+)
+
+// responseCode returns the http status of the generated error responses of the operations restAPI calls,
+// or 0 for any other error
+func responseCode(err error) int {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch err.(type) {
+		case *auth_policy.DeleteAuthPolicyNotFound,
+			*auth_policy.UpdateAuthPolicyNotFound,
+			*config.DeleteConfigNotFound,
+			*config.UpdateConfigNotFound,
+			*edge_router_policy.DeleteEdgeRouterPolicyNotFound,
+			*edge_router_policy.UpdateEdgeRouterPolicyNotFound,
+			*external_jwt_signer.DeleteExternalJWTSignerNotFound,
+			*external_jwt_signer.UpdateExternalJWTSignerNotFound,
+			*identity.DeleteIdentityNotFound,
+			*identity.DetailIdentityNotFound,
+			*identity.PatchIdentityNotFound,
+			*posture_checks.DeletePostureCheckNotFound,
+			*posture_checks.UpdatePostureCheckNotFound,
+			*service.DeleteServiceNotFound,
+			*service.UpdateServiceNotFound,
+			*service_edge_router_policy.DeleteServiceEdgeRouterPolicyNotFound,
+			*service_edge_router_policy.UpdateServiceEdgeRouterPolicyNotFound,
+			*service_policy.DeleteServicePolicyNotFound,
+			*service_policy.UpdateServicePolicyNotFound:
+			return http.StatusNotFound
+		case *auth_policy.CreateAuthPolicyUnauthorized,
+			*auth_policy.DeleteAuthPolicyUnauthorized,
+			*auth_policy.ListAuthPoliciesUnauthorized,
+			*auth_policy.UpdateAuthPolicyUnauthorized,
+			*config.CreateConfigUnauthorized,
+			*config.DeleteConfigUnauthorized,
+			*config.ListConfigTypesUnauthorized,
+			*config.ListConfigsUnauthorized,
+			*config.UpdateConfigUnauthorized,
+			*edge_router_policy.CreateEdgeRouterPolicyUnauthorized,
+			*edge_router_policy.DeleteEdgeRouterPolicyUnauthorized,
+			*edge_router_policy.ListEdgeRouterPoliciesUnauthorized,
+			*edge_router_policy.UpdateEdgeRouterPolicyUnauthorized,
+			*external_jwt_signer.CreateExternalJWTSignerUnauthorized,
+			*external_jwt_signer.DeleteExternalJWTSignerUnauthorized,
+			*external_jwt_signer.ListExternalJWTSignersUnauthorized,
+			*external_jwt_signer.UpdateExternalJWTSignerUnauthorized,
+			*identity.CreateIdentityUnauthorized,
+			*identity.DeleteIdentityUnauthorized,
+			*identity.DetailIdentityUnauthorized,
+			*identity.ListIdentitiesUnauthorized,
+			*identity.PatchIdentityUnauthorized,
+			*posture_checks.CreatePostureCheckUnauthorized,
+			*posture_checks.ListPostureChecksUnauthorized,
+			*posture_checks.UpdatePostureCheckUnauthorized,
+			*service.CreateServiceUnauthorized,
+			*service.DeleteServiceUnauthorized,
+			*service.ListServicesUnauthorized,
+			*service.UpdateServiceUnauthorized,
+			*service_edge_router_policy.CreateServiceEdgeRouterPolicyUnauthorized,
+			*service_edge_router_policy.DeleteServiceEdgeRouterPolicyUnauthorized,
+			*service_edge_router_policy.ListServiceEdgeRouterPoliciesUnauthorized,
+			*service_edge_router_policy.UpdateServiceEdgeRouterPolicyUnauthorized,
+			*service_policy.CreateServicePolicyUnauthorized,
+			*service_policy.DeleteServicePolicyUnauthorized,
+			*service_policy.ListServicePoliciesUnauthorized,
+			*service_policy.UpdateServicePolicyUnauthorized:
+			return http.StatusUnauthorized
+		case *auth_policy.CreateAuthPolicyTooManyRequests,
+			*auth_policy.DeleteAuthPolicyTooManyRequests,
+			*auth_policy.ListAuthPoliciesTooManyRequests,
+			*auth_policy.UpdateAuthPolicyTooManyRequests,
+			*config.CreateConfigTooManyRequests,
+			*config.DeleteConfigTooManyRequests,
+			*config.ListConfigTypesTooManyRequests,
+			*config.ListConfigsTooManyRequests,
+			*config.UpdateConfigTooManyRequests,
+			*edge_router_policy.CreateEdgeRouterPolicyTooManyRequests,
+			*edge_router_policy.DeleteEdgeRouterPolicyTooManyRequests,
+			*edge_router_policy.ListEdgeRouterPoliciesTooManyRequests,
+			*edge_router_policy.UpdateEdgeRouterPolicyTooManyRequests,
+			*external_jwt_signer.CreateExternalJWTSignerTooManyRequests,
+			*external_jwt_signer.DeleteExternalJWTSignerTooManyRequests,
+			*external_jwt_signer.ListExternalJWTSignersTooManyRequests,
+			*external_jwt_signer.UpdateExternalJWTSignerTooManyRequests,
+			*identity.CreateIdentityTooManyRequests,
+			*identity.DeleteIdentityTooManyRequests,
+			*identity.DetailIdentityTooManyRequests,
+			*identity.ListIdentitiesTooManyRequests,
+			*identity.PatchIdentityTooManyRequests,
+			*posture_checks.CreatePostureCheckTooManyRequests,
+			*posture_checks.DeletePostureCheckTooManyRequests,
+			*posture_checks.ListPostureChecksTooManyRequests,
+			*posture_checks.UpdatePostureCheckTooManyRequests,
+			*service.CreateServiceTooManyRequests,
+			*service.DeleteServiceTooManyRequests,
+			*service.ListServicesTooManyRequests,
+			*service.UpdateServiceTooManyRequests,
+			*service_edge_router_policy.CreateServiceEdgeRouterPolicyTooManyRequests,
+			*service_edge_router_policy.DeleteServiceEdgeRouterPolicyTooManyRequests,
+			*service_edge_router_policy.ListServiceEdgeRouterPoliciesTooManyRequests,
+			*service_edge_router_policy.UpdateServiceEdgeRouterPolicyTooManyRequests,
+			*service_policy.CreateServicePolicyTooManyRequests,
+			*service_policy.DeleteServicePolicyTooManyRequests,
+			*service_policy.ListServicePoliciesTooManyRequests,
+			*service_policy.UpdateServicePolicyTooManyRequests:
+			return http.StatusTooManyRequests
+		case *auth_policy.CreateAuthPolicyServiceUnavailable,
+			*auth_policy.DeleteAuthPolicyServiceUnavailable,
+			*auth_policy.ListAuthPoliciesServiceUnavailable,
+			*auth_policy.UpdateAuthPolicyServiceUnavailable,
+			*config.CreateConfigServiceUnavailable,
+			*config.DeleteConfigServiceUnavailable,
+			*config.ListConfigTypesServiceUnavailable,
+			*config.ListConfigsServiceUnavailable,
+			*config.UpdateConfigServiceUnavailable,
+			*edge_router_policy.CreateEdgeRouterPolicyServiceUnavailable,
+			*edge_router_policy.DeleteEdgeRouterPolicyServiceUnavailable,
+			*edge_router_policy.ListEdgeRouterPoliciesServiceUnavailable,
+			*edge_router_policy.UpdateEdgeRouterPolicyServiceUnavailable,
+			*external_jwt_signer.CreateExternalJWTSignerServiceUnavailable,
+			*external_jwt_signer.DeleteExternalJWTSignerServiceUnavailable,
+			*external_jwt_signer.ListExternalJWTSignersServiceUnavailable,
+			*external_jwt_signer.UpdateExternalJWTSignerServiceUnavailable,
+			*identity.CreateIdentityServiceUnavailable,
+			*identity.DeleteIdentityServiceUnavailable,
+			*identity.DetailIdentityServiceUnavailable,
+			*identity.ListIdentitiesServiceUnavailable,
+			*identity.PatchIdentityServiceUnavailable,
+			*posture_checks.CreatePostureCheckServiceUnavailable,
+			*posture_checks.DeletePostureCheckServiceUnavailable,
+			*posture_checks.ListPostureChecksServiceUnavailable,
+			*posture_checks.UpdatePostureCheckServiceUnavailable,
+			*service.CreateServiceServiceUnavailable,
+			*service.DeleteServiceServiceUnavailable,
+			*service.ListServicesServiceUnavailable,
+			*service.UpdateServiceServiceUnavailable,
+			*service_edge_router_policy.CreateServiceEdgeRouterPolicyServiceUnavailable,
+			*service_edge_router_policy.DeleteServiceEdgeRouterPolicyServiceUnavailable,
+			*service_edge_router_policy.ListServiceEdgeRouterPoliciesServiceUnavailable,
+			*service_edge_router_policy.UpdateServiceEdgeRouterPolicyServiceUnavailable,
+			*service_policy.CreateServicePolicyServiceUnavailable,
+			*service_policy.DeleteServicePolicyServiceUnavailable,
+			*service_policy.ListServicePoliciesServiceUnavailable,
+			*service_policy.UpdateServicePolicyServiceUnavailable:
+			return http.StatusServiceUnavailable
+		}
+	}
+	return 0
+}
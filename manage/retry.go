@@ -0,0 +1,62 @@
+package manage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retries of idempotent controller calls back off exponentially from retryBaseDelay up to
+// retryMaxDelay, with full jitter
+const (
+	retryAttempts  = 4
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// retry runs fn until it succeeds, fails with an error that is not transient, runs out of attempts or
+// ctx is done. only idempotent calls may be retried
+func retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !transient(err) || attempt == retryAttempts {
+			return err
+		}
+
+		delay := backoff(attempt)
+		logrus.Debugf("controller call failed (attempt %d of %d), retrying in %s: %v", attempt, retryAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// backoff returns a random delay up to retryBaseDelay doubled for each failed attempt, capped at retryMaxDelay
+func backoff(attempt int) time.Duration {
+	ceiling := retryBaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > retryMaxDelay {
+		ceiling = retryMaxDelay
+	}
+	return rand.N(ceiling) + 1
+}
+
+// transient reports whether a failed call may succeed if tried again
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
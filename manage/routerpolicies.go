@@ -0,0 +1,243 @@
+package manage
+
+import (
+	"context"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"strings"
+)
+
+// EdgeRouterPolicySpec is the desired shape of an edge router policy, which lets identities connect
+// to edge routers
+type EdgeRouterPolicySpec struct {
+	Name            string
+	Semantic        rest_model.Semantic
+	IdentityRoles   []string
+	EdgeRouterRoles []string
+}
+
+// ServiceEdgeRouterPolicySpec is the desired shape of a service edge router policy, which lets
+// services be reached through edge routers
+type ServiceEdgeRouterPolicySpec struct {
+	Name            string
+	Semantic        rest_model.Semantic
+	ServiceRoles    []string
+	EdgeRouterRoles []string
+}
+
+func (c *Client) planEdgeRouterPolicies(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.EdgeRouterPolicies {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListEdgeRouterPolicies(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list edge router policies named %s: %w", spec.Name, err)
+		}
+		if len(existing) > 0 && desired.Recreate {
+			steps = append(steps, c.deleteEdgeRouterPolicyStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "edge router policy", Name: name},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreateEdgeRouterPolicy(ctx, &rest_model.EdgeRouterPolicyCreate{
+						Name:            &name,
+						Semantic:        &spec.Semantic,
+						IdentityRoles:   spec.IdentityRoles,
+						EdgeRouterRoles: spec.EdgeRouterRoles,
+						Tags:            desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if actual.Semantic == nil || *actual.Semantic != spec.Semantic {
+			drift = append(drift, fmt.Sprintf("semantic -> %s", spec.Semantic))
+		}
+		if !sameStrings(actual.IdentityRoles, spec.IdentityRoles) {
+			drift = append(drift, fmt.Sprintf("identityRoles %v -> %v", []string(actual.IdentityRoles), spec.IdentityRoles))
+		}
+		if !sameStrings(actual.EdgeRouterRoles, spec.EdgeRouterRoles) {
+			drift = append(drift, fmt.Sprintf("edgeRouterRoles %v -> %v", []string(actual.EdgeRouterRoles), spec.EdgeRouterRoles))
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "edge router policy", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.UpdateEdgeRouterPolicy(ctx, id, &rest_model.EdgeRouterPolicyUpdate{
+						Name:            &name,
+						Semantic:        &spec.Semantic,
+						IdentityRoles:   spec.IdentityRoles,
+						EdgeRouterRoles: spec.EdgeRouterRoles,
+						Tags:            desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedEdgeRouterPolicies(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scoped {
+		if !wanted[*p.Name] {
+			steps = append(steps, c.deleteEdgeRouterPolicyStep(p))
+		}
+	}
+	return steps, nil
+}
+
+// scopedEdgeRouterPolicies lists the edge router policies tagged with the origin and the untagged policies whose names start with prefix
+func (c *Client) scopedEdgeRouterPolicies(ctx context.Context, origin Origin, prefix string) ([]*rest_model.EdgeRouterPolicyDetail, error) {
+	var result []*rest_model.EdgeRouterPolicyDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListEdgeRouterPolicies(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list edge router policies of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListEdgeRouterPolicies(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list edge router policies for %s: %w", prefix, err)
+		}
+		for _, p := range named {
+			// system policies are managed by the controller
+			if isLegacy(*p.Name, p.Tags, prefix) && (p.IsSystem == nil || !*p.IsSystem) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteEdgeRouterPolicyStep(p *rest_model.EdgeRouterPolicyDetail) step {
+	id := *p.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "edge router policy", Name: *p.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteEdgeRouterPolicy(ctx, id)
+		},
+	}
+}
+
+func (c *Client) planServiceEdgeRouterPolicies(ctx context.Context, desired DesiredState) ([]step, error) {
+	var steps []step
+	wanted := map[string]bool{}
+	for _, spec := range desired.ServiceEdgeRouterPolicies {
+		wanted[spec.Name] = true
+		existing, err := c.api.ListServiceEdgeRouterPolicies(ctx, nameFilter(spec.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not list service edge router policies named %s: %w", spec.Name, err)
+		}
+		if len(existing) > 0 && desired.Recreate {
+			steps = append(steps, c.deleteServiceEdgeRouterPolicyStep(existing[0]))
+			existing = nil
+		}
+		name := spec.Name
+		if len(existing) == 0 {
+			steps = append(steps, step{
+				Change: Change{Action: ActionCreate, Kind: "service edge router policy", Name: name},
+				apply: func(ctx context.Context) error {
+					_, err := c.api.CreateServiceEdgeRouterPolicy(ctx, &rest_model.ServiceEdgeRouterPolicyCreate{
+						Name:            &name,
+						Semantic:        &spec.Semantic,
+						ServiceRoles:    spec.ServiceRoles,
+						EdgeRouterRoles: spec.EdgeRouterRoles,
+						Tags:            desired.Origin.tags(),
+					})
+					return err
+				},
+			})
+			continue
+		}
+
+		actual := existing[0]
+		var drift []string
+		if actual.Semantic == nil || *actual.Semantic != spec.Semantic {
+			drift = append(drift, fmt.Sprintf("semantic -> %s", spec.Semantic))
+		}
+		if !sameStrings(actual.ServiceRoles, spec.ServiceRoles) {
+			drift = append(drift, fmt.Sprintf("serviceRoles %v -> %v", []string(actual.ServiceRoles), spec.ServiceRoles))
+		}
+		if !sameStrings(actual.EdgeRouterRoles, spec.EdgeRouterRoles) {
+			drift = append(drift, fmt.Sprintf("edgeRouterRoles %v -> %v", []string(actual.EdgeRouterRoles), spec.EdgeRouterRoles))
+		}
+		if !desired.Origin.owns(actual.Tags) {
+			drift = append(drift, "tags")
+		}
+		if len(drift) > 0 {
+			id := *actual.ID
+			steps = append(steps, step{
+				Change: Change{Action: ActionUpdate, Kind: "service edge router policy", Name: name, Detail: strings.Join(drift, ", ")},
+				apply: func(ctx context.Context) error {
+					return c.api.UpdateServiceEdgeRouterPolicy(ctx, id, &rest_model.ServiceEdgeRouterPolicyUpdate{
+						Name:            &name,
+						Semantic:        &spec.Semantic,
+						ServiceRoles:    spec.ServiceRoles,
+						EdgeRouterRoles: spec.EdgeRouterRoles,
+						Tags:            desired.Origin.retag(actual.Tags),
+					})
+				},
+			})
+		}
+	}
+
+	scoped, err := c.scopedServiceEdgeRouterPolicies(ctx, desired.Origin, desired.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range scoped {
+		if !wanted[*p.Name] {
+			steps = append(steps, c.deleteServiceEdgeRouterPolicyStep(p))
+		}
+	}
+	return steps, nil
+}
+
+// scopedServiceEdgeRouterPolicies lists the service edge router policies tagged with the origin and the untagged policies whose names start with prefix
+func (c *Client) scopedServiceEdgeRouterPolicies(ctx context.Context, origin Origin, prefix string) ([]*rest_model.ServiceEdgeRouterPolicyDetail, error) {
+	var result []*rest_model.ServiceEdgeRouterPolicyDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListServiceEdgeRouterPolicies(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list service edge router policies of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListServiceEdgeRouterPolicies(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list service edge router policies for %s: %w", prefix, err)
+		}
+		for _, p := range named {
+			if isLegacy(*p.Name, p.Tags, prefix) {
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) deleteServiceEdgeRouterPolicyStep(p *rest_model.ServiceEdgeRouterPolicyDetail) step {
+	id := *p.ID
+	return step{
+		Change: Change{Action: ActionDelete, Kind: "service edge router policy", Name: *p.Name},
+		apply: func(ctx context.Context) error {
+			return c.api.DeleteServiceEdgeRouterPolicy(ctx, id)
+		},
+	}
+}
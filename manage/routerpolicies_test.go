@@ -0,0 +1,69 @@
+package manage_test
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"slices"
+	"testing"
+)
+
+func routed() manage.DesiredState {
+	desired := demo()
+	desired.EdgeRouterPolicies = []manage.EdgeRouterPolicySpec{
+		{Name: "test_routers", Semantic: rest_model.SemanticAnyOf, IdentityRoles: []string{"#test_demo.clients"}, EdgeRouterRoles: []string{"#all"}},
+	}
+	desired.ServiceEdgeRouterPolicies = []manage.ServiceEdgeRouterPolicySpec{
+		{Name: "test_service_routers", Semantic: rest_model.SemanticAnyOf, ServiceRoles: []string{"#test_demo-services"}, EdgeRouterRoles: []string{"#all"}},
+	}
+	return desired
+}
+
+func TestReconcileRouterPolicies(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, routed()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.EdgeRouterPolicies()) != 1 || len(ctrl.ServiceEdgeRouterPolicies()) != 1 {
+		t.Fatalf("created %d edge router policies and %d service edge router policies",
+			len(ctrl.EdgeRouterPolicies()), len(ctrl.ServiceEdgeRouterPolicies()))
+	}
+
+	desired := routed()
+	desired.EdgeRouterPolicies[0].EdgeRouterRoles = []string{"#east"}
+	report, err := c.Reconcile(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Equal(got, []string{"update edge router policy test_routers"}) {
+		t.Errorf("changing the edge router roles made %v", got)
+	}
+	if roles := ctrl.EdgeRouterPolicies()[0].EdgeRouterRoles; !slices.Equal(roles, []string{"#east"}) {
+		t.Errorf("the edge router policy has the roles %v", roles)
+	}
+
+	desired.EdgeRouterPolicies = nil
+	desired.ServiceEdgeRouterPolicies = nil
+	if _, err := c.Reconcile(ctx, desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.EdgeRouterPolicies()) != 0 || len(ctrl.ServiceEdgeRouterPolicies()) != 0 {
+		t.Error("router policies that are no longer desired were kept")
+	}
+}
+
+func TestTeardownRouterPolicies(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, routed()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Teardown(ctx, "test", "test_", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.EdgeRouterPolicies()) != 0 || len(ctrl.ServiceEdgeRouterPolicies()) != 0 {
+		t.Error("the teardown left router policies")
+	}
+}
@@ -0,0 +1,98 @@
+package manage
+
+import (
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"time"
+)
+
+// Version is recorded on every object appetizer creates. set it at build time with
+// -ldflags "-X openziti-test-kitchen/appetizer/manage.Version=<version>"
+var Version = "dev"
+
+// tag keys written on every object appetizer creates
+const (
+	TagInstance  = "appetizerInstance"
+	TagComponent = "appetizerComponent"
+	TagCreatedAt = "appetizerCreatedAt"
+	TagVersion   = "appetizerVersion"
+)
+
+// components that create objects
+const (
+	ComponentNetwork = "network"
+	ComponentVisitor = "visitor"
+	// ComponentWorkshop identities are provisioned in bulk. the visitor reaper leaves them alone
+	ComponentWorkshop = "workshop"
+)
+
+// Origin records which demo instance, and which part of it, created an object
+type Origin struct {
+	Instance  string
+	Component string
+}
+
+// tags builds the tags for a new object from this origin
+func (o Origin) tags() *rest_model.Tags {
+	return &rest_model.Tags{SubTags: map[string]interface{}{
+		TagInstance:  o.Instance,
+		TagComponent: o.Component,
+		TagCreatedAt: time.Now().UTC().Format(time.RFC3339),
+		TagVersion:   Version,
+	}}
+}
+
+// retag returns the existing tags with this origin applied, keeping the original creation time
+func (o Origin) retag(existing *rest_model.Tags) *rest_model.Tags {
+	tags := o.tags()
+	if existing != nil {
+		for k, v := range existing.SubTags {
+			if _, ours := tags.SubTags[k]; !ours || k == TagCreatedAt {
+				tags.SubTags[k] = v
+			}
+		}
+	}
+	return tags
+}
+
+// owns reports whether the tags were written by this origin. an empty component matches any component
+func (o Origin) owns(tags *rest_model.Tags) bool {
+	instance := tagString(tags, TagInstance)
+	if instance == "" || instance != o.Instance {
+		return false
+	}
+	return o.Component == "" || tagString(tags, TagComponent) == o.Component
+}
+
+// filter selects the objects created by this origin. an empty component matches any component
+func (o Origin) filter() string {
+	f := fmt.Sprintf("tags.%s = %s", TagInstance, quoted(o.Instance))
+	if o.Component != "" {
+		f += fmt.Sprintf(" and tags.%s = %s", TagComponent, quoted(o.Component))
+	}
+	return f
+}
+
+func tagString(tags *rest_model.Tags, key string) string {
+	if tags == nil {
+		return ""
+	}
+	if v, found := tags.SubTags[key]; found {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// CreatedAt reads the creation time appetizer tagged an object with, falling back to the
+// controller's creation time for untagged objects
+func CreatedAt(b rest_model.BaseEntity) time.Time {
+	if t, err := time.Parse(time.RFC3339, tagString(b.Tags, TagCreatedAt)); err == nil {
+		return t
+	}
+	if b.CreatedAt != nil {
+		return time.Time(*b.CreatedAt)
+	}
+	return time.Time{}
+}
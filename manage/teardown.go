@@ -0,0 +1,194 @@
+package manage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"regexp"
+	"strings"
+)
+
+// visitorName matches the names common.GetRandomName gives visitors who don't choose one. they contain
+// underscores after the prefix, yet untagged identities with such names still count as the instance's own
+var visitorName = regexp.MustCompile(`^randomizer_[A-Za-z0-9_-]{8}$`)
+
+// Teardown deletes every object tagged with the instance, visitors included, and the legacy
+// untagged objects named with prefix, see isLegacy. allPrefixed also deletes untagged objects
+// whose names merely start with prefix
+func (c *Client) Teardown(ctx context.Context, instance string, prefix string, allPrefixed bool) (Report, error) {
+	steps, err := c.planTeardown(ctx, instance, prefix, allPrefixed)
+	if err != nil {
+		return Report{}, err
+	}
+	return c.apply(ctx, instance, steps)
+}
+
+// PlanTeardown reports what Teardown would delete without deleting anything
+func (c *Client) PlanTeardown(ctx context.Context, instance string, prefix string, allPrefixed bool) (Report, error) {
+	steps, err := c.planTeardown(ctx, instance, prefix, allPrefixed)
+	if err != nil {
+		return Report{}, err
+	}
+	return changes(steps), nil
+}
+
+func (c *Client) planTeardown(ctx context.Context, instance string, prefix string, allPrefixed bool) ([]step, error) {
+	if instance == "" && prefix == "" {
+		return nil, errors.New("refusing to tear down without an instance or a name prefix")
+	}
+	origin := Origin{Instance: instance}
+	var steps []step
+
+	erps, err := c.scopedEdgeRouterPolicies(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range erps {
+		steps = append(steps, c.deleteEdgeRouterPolicyStep(p))
+	}
+
+	serps, err := c.scopedServiceEdgeRouterPolicies(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range serps {
+		steps = append(steps, c.deleteServiceEdgeRouterPolicyStep(p))
+	}
+
+	policies, err := c.scopedServicePolicies(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		steps = append(steps, c.deleteServicePolicyStep(p))
+	}
+
+	services, err := c.scopedServices(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		steps = append(steps, c.deleteServiceStep(s))
+	}
+
+	configs, err := c.scopedConfigs(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		steps = append(steps, c.deleteConfigStep(cfg))
+	}
+
+	checks, err := c.scopedPostureChecks(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range checks {
+		steps = append(steps, c.deletePostureCheckStep(p))
+	}
+
+	identities, err := c.scopedIdentities(ctx, origin, prefix, allPrefixed)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range identities {
+		steps = append(steps, c.deleteIdentityStep(i))
+	}
+
+	authPolicies, err := c.scopedAuthPolicies(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range authPolicies {
+		steps = append(steps, c.deleteAuthPolicyStep(p))
+	}
+
+	signers, err := c.scopedExternalJWTSigners(ctx, origin, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range signers {
+		steps = append(steps, c.deleteExternalJWTSignerStep(s))
+	}
+	return steps, nil
+}
+
+// ownedBy reports whether a name belongs to the instance with the given name prefix: it is in scope or it is
+// a generated visitor name. allPrefixed also matches names that merely start with prefix
+func ownedBy(name string, prefix string, allPrefixed bool) bool {
+	rest, found := strings.CutPrefix(name, prefix)
+	if !found || rest == "" {
+		return false
+	}
+	return allPrefixed || inScope(name, prefix) || visitorName.MatchString(rest)
+}
+
+// isLegacyVisitor reports whether an untagged identity has the generated visitor name of the instance with
+// the given name prefix
+func isLegacyVisitor(name string, tags *rest_model.Tags, prefix string) bool {
+	rest, found := strings.CutPrefix(name, prefix)
+	return found && tagString(tags, TagInstance) == "" && visitorName.MatchString(rest)
+}
+
+// scopedIdentities lists the identities tagged with the origin and the untagged identities whose names start with
+// prefix. untagged visitors may have names the prefix rule of other objects leaves alone, see ownedBy
+func (c *Client) scopedIdentities(ctx context.Context, origin Origin, prefix string, allPrefixed bool) ([]*rest_model.IdentityDetail, error) {
+	var result []*rest_model.IdentityDetail
+	if origin.Instance != "" {
+		owned, err := c.api.ListIdentities(ctx, origin.filter())
+		if err != nil {
+			return nil, fmt.Errorf("could not list identities of %s: %w", origin.Instance, err)
+		}
+		result = append(result, owned...)
+	}
+	if prefix != "" {
+		named, err := c.api.ListIdentities(ctx, containsFilter(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("could not list identities for %s: %w", prefix, err)
+		}
+		for _, i := range named {
+			if tagString(i.Tags, TagInstance) == "" && ownedBy(*i.Name, prefix, allPrefixed) {
+				result = append(result, i)
+			}
+		}
+	}
+	return result, nil
+}
+
+// FindIdentitiesByOrigin lists the identities tagged with the origin
+func (c *Client) FindIdentitiesByOrigin(ctx context.Context, origin Origin) ([]*rest_model.IdentityDetail, error) {
+	return c.scopedIdentities(ctx, origin, "", false)
+}
+
+// FindVisitorIdentities lists the identities tagged with the visitor origin and the untagged visitors
+// created before tagging, whose names are prefix and a generated visitor name
+func (c *Client) FindVisitorIdentities(ctx context.Context, origin Origin, prefix string) ([]*rest_model.IdentityDetail, error) {
+	result, err := c.FindIdentitiesByOrigin(ctx, origin)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		return result, nil
+	}
+	named, err := c.api.ListIdentities(ctx, containsFilter(prefix))
+	if err != nil {
+		return nil, fmt.Errorf("could not list identities for %s: %w", prefix, err)
+	}
+	for _, i := range named {
+		if isLegacyVisitor(*i.Name, i.Tags, prefix) {
+			result = append(result, i)
+		}
+	}
+	return result, nil
+}
+
+// FindServicesByOrigin lists the services tagged with the origin
+func (c *Client) FindServicesByOrigin(ctx context.Context, origin Origin) ([]*rest_model.ServiceDetail, error) {
+	return c.scopedServices(ctx, origin, "")
+}
+
+// FindServicePoliciesByOrigin lists the service policies tagged with the origin
+func (c *Client) FindServicePoliciesByOrigin(ctx context.Context, origin Origin) ([]*rest_model.ServicePolicyDetail, error) {
+	return c.scopedServicePolicies(ctx, origin, "")
+}
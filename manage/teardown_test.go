@@ -0,0 +1,87 @@
+package manage_test
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"slices"
+	"testing"
+)
+
+func identityNames(ctrl *managetest.Controller) []string {
+	var names []string
+	for _, i := range ctrl.Identities() {
+		names = append(names, *i.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestTeardown(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	ott := manage.Enrollment{Method: manage.EnrollmentOTT}
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+	visitors := manage.Origin{Instance: "test", Component: manage.ComponentVisitor}
+	if _, err := c.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_cam_c", nil, ott); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIdentity(ctx, manage.Origin{Instance: "test_b"}, rest_model.IdentityTypeUser, "test_b_server", nil, ott); err != nil {
+		t.Fatal(err)
+	}
+	// untagged identities made before objects were tagged: a generated visitor name, a visitor who chose a
+	// name with an underscore and objects of other instances
+	identType := rest_model.IdentityTypeUser
+	for _, name := range []string{"test_randomizer_a-B_9xYz", "test_amy_b", "other_test_x", "unrelated"} {
+		if _, err := ctrl.CreateIdentity(ctx, &rest_model.IdentityCreate{Name: &name, Type: &identType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := c.PlanTeardown(ctx, "test", "test_", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctrl.Services()) != 1 {
+		t.Errorf("planning %v deleted objects", changed(plan))
+	}
+
+	report, err := c.Teardown(ctx, "test", "test_", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changed(report), changed(plan)) {
+		t.Errorf("the teardown made %v, but %v were planned", changed(report), changed(plan))
+	}
+	if len(ctrl.Services()) != 0 || len(ctrl.ServicePolicies()) != 0 {
+		t.Errorf("the teardown %v left services or service policies", changed(report))
+	}
+	if left, want := identityNames(ctrl), []string{"other_test_x", "test_amy_b", "test_b_server", "unrelated"}; !slices.Equal(left, want) {
+		t.Errorf("after the teardown %v, %v are left, expected %v", changed(report), left, want)
+	}
+
+	report, err = c.Teardown(ctx, "test", "test_", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changed(report); !slices.Equal(got, []string{"delete identity test_amy_b"}) {
+		t.Errorf("tearing down every prefixed object made %v", got)
+	}
+}
+
+func TestTeardownNeedsAnInstance(t *testing.T) {
+	c, ctrl := managetest.NewClient()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, demo()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Teardown(ctx, "", "", true); err == nil {
+		t.Error("tearing down without an instance or a prefix was not refused")
+	}
+	if len(ctrl.Services()) != 1 {
+		t.Error("objects were deleted without an instance or a prefix")
+	}
+}
@@ -0,0 +1,227 @@
+// Package mockidp is a minimal OpenID Connect provider for trying the OIDC login locally. it signs in anyone
+// with whatever name they type, so it must never guard anything real.
+package mockidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"net/url"
+	"openziti-test-kitchen/appetizer/clients/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyID    = "mockidp"
+	codeTTL  = time.Minute
+	tokenTTL = time.Hour
+)
+
+// Provider issues RS256 signed id tokens for the authorization code flow. it serves discovery at
+// /.well-known/openid-configuration relative to where it is mounted
+type Provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is an authorization code waiting to be exchanged for tokens
+type grant struct {
+	clientID  string
+	redirect  string
+	subject   string
+	nonce     string
+	expiresAt time.Time
+}
+
+// New returns a provider whose tokens name issuer, the public url the provider is mounted at
+func New(issuer string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID))
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		key:    key,
+		signer: signer,
+		codes:  map[string]grant{},
+	}, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		p.discovery(w)
+	case "/jwks":
+		p.jwks(w)
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{string(jose.RS256)},
+		"scopes_supported":                      []string{"openid", "profile"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter) {
+	writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: keyID, Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Mock identity provider</title></head>
+<body>
+<h1>Mock identity provider</h1>
+<p>This provider signs in anyone. Choose the subject your identity will be bound to.</p>
+<form method="POST">
+  {{ range $k, $v := .Params }}<input type="hidden" name="{{ $k }}" value="{{ index $v 0 }}">
+  {{ end }}<input type="text" name="login" placeholder="subject" required autofocus>
+  <button type="submit">Sign in</button>
+</form>
+</body>
+</html>`))
+
+// authorize shows a login form and, once a subject is posted, redirects back to the client with a code
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request: Your request is invalid.", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() || r.Form.Get("client_id") == "" || r.Form.Get("response_type") != "code" {
+		http.Error(w, "Bad Request: a client_id, an absolute redirect_uri and response_type=code are required.", http.StatusBadRequest)
+		return
+	}
+
+	subject := strings.TrimSpace(r.Form.Get("login"))
+	if r.Method != http.MethodPost || subject == "" {
+		params := url.Values{}
+		for _, k := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce"} {
+			if v := r.Form.Get(k); v != "" {
+				params.Set(k, v)
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, struct{ Params url.Values }{params})
+		return
+	}
+
+	code, _ := common.GenerateRandomID(24)
+	p.mu.Lock()
+	for c, g := range p.codes {
+		if time.Now().After(g.expiresAt) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = grant{
+		clientID:  r.Form.Get("client_id"),
+		redirect:  redirect.String(),
+		subject:   subject,
+		nonce:     r.Form.Get("nonce"),
+		expiresAt: time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	logrus.Infof("mock idp signed in %s", subject)
+
+	q := redirect.Query()
+	q.Set("code", code)
+	if state := r.Form.Get("state"); state != "" {
+		q.Set("state", state)
+	}
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for an id token and an access token carrying the same claims
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.Form.Get("client_id")
+	}
+
+	code := r.Form.Get("code")
+	p.mu.Lock()
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if !found || time.Now().After(g.expiresAt) || g.clientID != clientID || g.redirect != r.Form.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:    p.issuer,
+		Subject:   g.subject,
+		Audience:  jwt.Audience{g.clientID},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(tokenTTL)),
+	}
+	extra := map[string]interface{}{"name": g.subject}
+	if g.nonce != "" {
+		extra["nonce"] = g.nonce
+	}
+	signed, err := jwt.Signed(p.signer).Claims(claims).Claims(extra).Serialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": signed,
+		"id_token":     signed,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = fmt.Fprintf(w, `{"error":%q}`, code)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(v)
+}
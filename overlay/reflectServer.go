@@ -31,15 +31,26 @@ const (
 	OFFENSIVE
 )
 
+func (o OffensiveResult) String() string {
+	switch o {
+	case NOT_OFFENSIVE:
+		return "not-offensive"
+	case OFFENSIVE:
+		return "offensive"
+	}
+	return "unclassified"
+}
+
 type ReflectServer struct {
-	topic            underlay.Topic[string]
+	rooms            *underlay.Rooms[underlay.ChatEvent]
+	instance         string
 	classifierClient *http.Client
 	zitiCtx          ziti.Context
 	mattermostClient *http.Client
 	mattermostUrl    string
 }
 
-func StartReflectServer(zitiCfg *ziti.Config, serviceName string, topic underlay.Topic[string]) {
+func StartReflectServer(zitiCfg *ziti.Config, serviceName string, instance string, rooms *underlay.Rooms[underlay.ChatEvent]) {
 	ctx, err := ziti.NewContext(zitiCfg)
 	if err != nil {
 		logrus.Fatal(err)
@@ -52,7 +63,8 @@ func StartReflectServer(zitiCfg *ziti.Config, serviceName string, topic underlay
 
 	newClassifierClient := common.NewZitiClientFromContext(ctx)
 	r := &ReflectServer{
-		topic:            topic,
+		rooms:            rooms,
+		instance:         instance,
 		classifierClient: newClassifierClient,
 	}
 
@@ -104,6 +116,7 @@ func (r ReflectServer) accept(conn edge.Conn) {
 
 	i := 0
 	p := bluemonday.StrictPolicy()
+	room := underlay.DefaultRoom
 
 	//line delimited
 	for {
@@ -125,7 +138,9 @@ func (r ReflectServer) accept(conn edge.Conn) {
 		logrus.Infof("                  read : %s", strings.TrimSpace(line))
 
 		var resp string
-		if goaway.IsProfane(line) {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "/join" {
+			resp = r.join(&room, fields[1:])
+		} else if goaway.IsProfane(line) {
 			resp = fmt.Sprintf("please remember to be kind and keep it clean. not sending your message. you sent me: %s", line)
 		} else {
 			//let it through
@@ -158,15 +173,25 @@ func (r ReflectServer) accept(conn edge.Conn) {
 				relayMessage = true
 			}
 			if relayMessage {
-				r.topic.Notify(fmt.Sprintf("event: notify\n"))
-				html := p.Sanitize(line)
 				source := conn.SourceIdentifier()
 				if strings.ContainsAny(source, "@") {
 					//strip out anything after the @...
 					parts := strings.Split(source, "@")
 					source = parts[0]
 				}
-				r.topic.Notify(fmt.Sprintf("data: %s:%s\n\n", source, html))
+				topic, err := r.rooms.Room(room)
+				if err != nil {
+					logrus.Errorf("could not relay the message to room %s: %v", room, err)
+				} else {
+					topic.Notify(underlay.ChatEvent{
+						Sender:         p.Sanitize(source),
+						Text:           p.Sanitize(strings.TrimSpace(line)),
+						Timestamp:      time.Now().UTC(),
+						Classification: isOffensive.String(),
+						Instance:       r.instance,
+						Room:           room,
+					})
+				}
 			}
 			r.notifyMattermost(ma, conn.SourceIdentifier())
 		}
@@ -178,6 +203,20 @@ func (r ReflectServer) accept(conn edge.Conn) {
 	}
 }
 
+// join moves the connection to the room named by the rest of a /join line. a bare /join goes back to the
+// default room
+func (r ReflectServer) join(current *string, args []string) string {
+	name, err := underlay.ParseRoom(strings.Join(args, " "))
+	if err == nil {
+		_, err = r.rooms.Room(name)
+	}
+	if err != nil {
+		return fmt.Sprintf("could not join the room: %v", err)
+	}
+	*current = name
+	return fmt.Sprintf("you joined room %s. your messages now only go to this room", name)
+}
+
 func readLineWithTimeout(conn net.Conn, duration time.Duration, buff []byte) (string, error) {
 	// Create a buffered reader
 	reader := bufio.NewReader(conn)
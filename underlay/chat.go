@@ -0,0 +1,34 @@
+package underlay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ChatEvent is a message the reflect service relays to the chat page
+type ChatEvent struct {
+	// Sender and Text are sanitized with bluemonday's strict policy by the reflect service. /sse is open to any
+	// origin, so they stay safe for consumers that insert them as HTML
+	Sender string `json:"sender"`
+	Text   string `json:"text"`
+	// Timestamp is when the reflect service received the message
+	Timestamp time.Time `json:"timestamp"`
+	// Classification is what the offensiveness classifier made of the text
+	Classification string `json:"classification"`
+	// Instance is the demo instance the message was sent to
+	Instance string `json:"instance"`
+	// Room is the chat room the message was sent to
+	Room string `json:"room"`
+}
+
+// writeChatEvent writes the event as a single server-sent event with the given id whose data is the event as JSON
+func writeChatEvent(w io.Writer, id string, ev ChatEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: notify\ndata: %s\n\n", id, data)
+	return err
+}
@@ -0,0 +1,35 @@
+package underlay
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteChatEvent(t *testing.T) {
+	ev := ChatEvent{
+		Sender:         "amy",
+		Text:           "hello\n\ndata: <b>forged</b>",
+		Timestamp:      time.Date(2026, 10, 18, 9, 12, 44, 0, time.UTC),
+		Classification: "not-offensive",
+		Instance:       "test",
+	}
+	var b strings.Builder
+	if err := writeChatEvent(&b, "epoch-7", ev); err != nil {
+		t.Fatal(err)
+	}
+
+	// a text with blank lines must not end the event early or add fields to it
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n\n"), "\n")
+	if len(lines) != 3 || lines[0] != "id: epoch-7" || lines[1] != "event: notify" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("wrote the event as %q", b.String())
+	}
+	var got ChatEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != ev {
+		t.Errorf("the data decodes as %+v, expected %+v", got, ev)
+	}
+}
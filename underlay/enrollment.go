@@ -0,0 +1,34 @@
+package underlay
+
+import (
+	"errors"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+)
+
+// enrollmentCA is the id of the third party CA visitors may enroll with, from OPENZITI_ENROLLMENT_CA
+func enrollmentCA() string {
+	return os.Getenv("OPENZITI_ENROLLMENT_CA")
+}
+
+// enrollmentMethods lists the methods visitors can choose from on this server
+func enrollmentMethods() []manage.EnrollmentMethod {
+	methods := []manage.EnrollmentMethod{manage.EnrollmentOTT, manage.EnrollmentUPDB}
+	if enrollmentCA() != "" {
+		methods = append(methods, manage.EnrollmentOTTCA)
+	}
+	return methods
+}
+
+// visitorEnrollment returns the enrollment for the method a visitor asked for. ott is used when none was
+// asked for, ottca is only offered when a CA is configured
+func visitorEnrollment(method string) (manage.Enrollment, error) {
+	m, err := manage.ParseEnrollmentMethod(method)
+	if err != nil {
+		return manage.Enrollment{}, err
+	}
+	if m == manage.EnrollmentOTTCA && enrollmentCA() == "" {
+		return manage.Enrollment{}, errors.New("ottca enrollment is not available on this server")
+	}
+	return manage.Enrollment{Method: m, CA: enrollmentCA()}, nil
+}
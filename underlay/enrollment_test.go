@@ -0,0 +1,76 @@
+package underlay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openziti-test-kitchen/appetizer/manage"
+	"strings"
+	"testing"
+)
+
+func sampleWith(u Server, method string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	u.sample(w, httptest.NewRequest(http.MethodGet, "/sample?"+url.Values{"enrollment": {method}}.Encode(), nil))
+	return w
+}
+
+func TestPasswordEnrollment(t *testing.T) {
+	u, ctrl := newTestServer(t)
+
+	w := sampleWith(u, "updb")
+	if w.Code != http.StatusOK || w.Header().Get("X-Enrollment-Method") != string(manage.EnrollmentUPDB) {
+		t.Fatalf("a sample enrolling with a password returned %d %s", w.Code, w.Header().Get("X-Enrollment-Method"))
+	}
+	sample := ctrl.Identities()[0]
+	if sample.Enrollment.Updb == nil || w.Body.String() != sample.Enrollment.Updb.JWT {
+		t.Errorf("the response %q is not the identity's password enrollment token", w.Body)
+	}
+
+	// the token can be downloaded again and names its method
+	w = httptest.NewRecorder()
+	u.downloadToken(w, httptest.NewRequest(http.MethodGet, "/download-token?token="+*sample.ID, nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Enrollment-Method") != string(manage.EnrollmentUPDB) || w.Body.String() != sample.Enrollment.Updb.JWT {
+		t.Errorf("downloading the password enrollment token returned %d %s %q", w.Code, w.Header().Get("X-Enrollment-Method"), w.Body)
+	}
+}
+
+func TestCertificateEnrollmentNeedsACA(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	if w := sampleWith(u, "ottca"); w.Code != http.StatusBadRequest {
+		t.Errorf("enrolling with a certificate without a CA returned %d", w.Code)
+	}
+
+	t.Setenv("OPENZITI_ENROLLMENT_CA", "visitor-ca")
+	w := sampleWith(u, "ottca")
+	if w.Code != http.StatusOK || w.Header().Get("X-Enrollment-Method") != string(manage.EnrollmentOTTCA) {
+		t.Fatalf("a sample enrolling with a certificate returned %d %s", w.Code, w.Header().Get("X-Enrollment-Method"))
+	}
+	if ottca := ctrl.Identities()[0].Enrollment.Ottca; ottca == nil || ottca.CaID != "visitor-ca" {
+		t.Errorf("the identity does not enroll with the configured CA: %+v", ottca)
+	}
+}
+
+func TestMetaListsEnrollmentMethods(t *testing.T) {
+	u, _ := newTestServer(t)
+	meta := func() map[string]string {
+		w := httptest.NewRecorder()
+		u.meta(w, httptest.NewRequest(http.MethodGet, "/meta", nil))
+		// clients decode /meta into a map of strings
+		var m map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+			t.Fatalf("/meta is not a map of strings: %v", err)
+		}
+		return m
+	}
+
+	m := meta()
+	if m["qualifier"] != "test" || m["enrollmentMethods"] != "ott,updb" {
+		t.Errorf("/meta returned %v", m)
+	}
+	t.Setenv("OPENZITI_ENROLLMENT_CA", "visitor-ca")
+	if methods := meta()["enrollmentMethods"]; !strings.HasSuffix(methods, ",ottca") {
+		t.Errorf("a server with a CA offers %s", methods)
+	}
+}
@@ -1,8 +1,10 @@
 package underlay
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/caddyserver/certmagic"
 	"github.com/openziti/edge-api/rest_model"
@@ -11,8 +13,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"openziti-test-kitchen/appetizer/clients/common"
 	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/mockidp"
 	"os"
 	"strings"
 	"text/template"
@@ -20,46 +24,203 @@ import (
 )
 
 type Server struct {
-	topic              Topic[string]
+	rooms              *Rooms[ChatEvent]
 	instanceIdentifier string
+	ctrl               *manage.Client
+	reaper             *Reaper
+	// overflow is what happens to the messages of an event stream subscriber that falls behind
+	overflow OverflowPolicy
+	// oidc is set when visitors log in with an OpenID Connect provider instead of choosing a name
+	oidc *oidcLogin
 }
 
-func NewUnderlayServer(topic Topic[string], instanceIdentifier string) Server {
-	return Server{
-		topic:              topic,
+func NewUnderlayServer(rooms *Rooms[ChatEvent], instanceIdentifier string, ctrl *manage.Client) Server {
+	u := Server{
+		rooms:              rooms,
 		instanceIdentifier: instanceIdentifier,
+		ctrl:               ctrl,
+		overflow:           OverflowPolicyFromEnv(),
 	}
+	u.reaper = NewReaper(ctrl, u.origin(manage.ComponentVisitor), u.scopedName(""), ReaperConfigFromEnv())
+	if cfg := OIDCConfigFromEnv(); cfg.Enabled() {
+		u.oidc = newOIDCLogin(cfg)
+	}
+	return u
 }
 
-func (u Server) Prepare(identityName string, forceRecreate bool) *ziti.Config {
-	logrus.Infof("removing demo configuration from " + manage.CtrlAddress)
-
+// Prepare reconciles the demo network and returns the enrolled server identity. a previously
+// enrolled server identity is reused when the controller still accepts it
+func (u Server) Prepare(ctx context.Context, identityName string, forceRecreate bool) (*ziti.Config, error) {
 	// make the identity based on the instanceIdentifier
 	svrId := u.scopedName(identityName)
-	reflectSvcName := u.ReflectServiceName()
+	idFile := serverIdentityFile(svrId)
+	saved, err := u.loadServerIdentity(ctx, svrId, idFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("reconciling demo configuration on %s for identity %s", u.ctrl.CtrlAddress(), svrId)
+	desired, err := u.desiredState(ctx, svrId, forceRecreate, saved == nil)
+	if err != nil {
+		return nil, err
+	}
+	report, err := u.ctrl.Reconcile(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("demo configuration reconciled:\n%s", report)
+	if saved != nil {
+		logrus.Infof("reusing the server identity saved at %s", idFile)
+		return saved, nil
+	}
+
+	select {
+	case <-time.After(time.Second):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	cfg, err := u.ctrl.EnrollIdentity(ctx, svrId)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveServerIdentity(idFile, cfg); err != nil {
+		logrus.Warnf("could not save the server identity, it will be enrolled again on the next start: %v", err)
+	} else {
+		logrus.Infof("server identity saved to %s", idFile)
+	}
+	return cfg, nil
+}
+
+// Plan reports the controller changes Prepare would make without making them
+func (u Server) Plan(ctx context.Context, identityName string, forceRecreate bool) (manage.Report, error) {
+	svrId := u.scopedName(identityName)
+	saved, err := u.loadServerIdentity(ctx, svrId, serverIdentityFile(svrId))
+	if err != nil {
+		return manage.Report{}, err
+	}
+	desired, err := u.desiredState(ctx, svrId, forceRecreate, saved == nil)
+	if err != nil {
+		return manage.Report{}, err
+	}
+	return u.ctrl.Plan(ctx, desired)
+}
+
+// Teardown deletes every controller object scoped to this instance, including visitor identities,
+// and removes the saved server identity. allPrefixed also deletes untagged objects named with another
+// underscore after the instance prefix, such as visitors who chose a name with an underscore
+func (u Server) Teardown(ctx context.Context, identityName string, allPrefixed bool) (manage.Report, error) {
+	report, err := u.ctrl.Teardown(ctx, u.instanceTag(), u.scopedName(""), allPrefixed)
+	if err != nil {
+		return report, err
+	}
+	idFile := serverIdentityFile(u.scopedName(identityName))
+	if err := os.Remove(idFile); err == nil {
+		logrus.Infof("removed the saved server identity at %s", idFile)
+	} else if !os.IsNotExist(err) {
+		logrus.Warnf("could not remove the saved server identity at %s: %v", idFile, err)
+	}
+	return report, nil
+}
+
+// PlanTeardown reports what Teardown would delete without deleting anything
+func (u Server) PlanTeardown(ctx context.Context, allPrefixed bool) (manage.Report, error) {
+	return u.ctrl.PlanTeardown(ctx, u.instanceTag(), u.scopedName(""), allPrefixed)
+}
+
+// desiredState describes the services, policies and server identity this instance needs
+func (u Server) desiredState(ctx context.Context, svrId string, forceRecreate bool, reenroll bool) (manage.DesiredState, error) {
 	svcAttrName := u.scopedName("demo-services")
-	httpSvcName := u.HttpServiceName()
-	bindSp := u.scopedName("demo-server-bind")
 	bindSpRole := u.scopedName("demo.servers")
-	dialSp := u.scopedName("demo-server-dial")
 	dialSpRole := u.scopedName("demo.clients")
-	manage.DeleteIdentity(svrId)
-	if forceRecreate {
-		manage.DeleteServicePolicy(bindSp)
-		manage.DeleteServicePolicy(dialSp)
-		manage.DeleteService(reflectSvcName)
-		manage.DeleteService(httpSvcName)
-	}
-
-	logrus.Infof("adding demo configuration to %s for identity %s", manage.CtrlAddress, svrId)
-	manage.CreateService(reflectSvcName, svcAttrName)
-	manage.CreateService(httpSvcName, svcAttrName)
-	manage.CreateServicePolicy(dialSp, rest_model.DialBindDial, rest_model.Roles{"#" + dialSpRole}, rest_model.Roles{"#" + svcAttrName})
-	manage.CreateServicePolicy(bindSp, rest_model.DialBindBind, rest_model.Roles{"#" + bindSpRole}, rest_model.Roles{"#" + svcAttrName})
-	bindAttributes := &rest_model.Attributes{bindSpRole, "classifier-clients"}
-	_ = manage.CreateIdentity(rest_model.IdentityTypeDevice, svrId, bindAttributes)
-	time.Sleep(time.Second)
-	return manage.EnrollIdentity(svrId)
+	httpIntercept := u.scopedName("httpService.intercept.v1")
+	httpHost := u.scopedName("httpService.host.v1")
+	desired := manage.DesiredState{
+		Origin:   u.origin(manage.ComponentNetwork),
+		Prefix:   u.scopedName(""),
+		Recreate: forceRecreate,
+		Configs: []manage.ConfigSpec{
+			// lets tunnelers (ZDE, ZME, ziti-edge-tunnel) reach the http service by name
+			{Name: httpIntercept, Type: "intercept.v1", Data: map[string]interface{}{
+				"protocols": []string{"tcp"},
+				"addresses": []string{u.HttpInterceptAddress()},
+				"portRanges": []map[string]int{
+					{"low": httpInterceptPort, "high": httpInterceptPort},
+				},
+			}},
+			// the demo server binds the service with the sdk, which ignores host configs. this one lets
+			// a tunneler host the service in its place
+			{Name: httpHost, Type: "host.v1", Data: map[string]interface{}{
+				"protocol": "tcp",
+				"address":  "localhost",
+				"port":     httpInterceptPort,
+			}},
+		},
+		Services: []manage.ServiceSpec{
+			{Name: u.ReflectServiceName(), RoleAttributes: []string{svcAttrName}, EncryptionRequired: true},
+			{Name: u.HttpServiceName(), RoleAttributes: []string{svcAttrName}, EncryptionRequired: true, Configs: []string{httpIntercept, httpHost}},
+		},
+		ServicePolicies: []manage.ServicePolicySpec{
+			{
+				Name:          u.scopedName("demo-server-dial"),
+				Type:          rest_model.DialBindDial,
+				Semantic:      rest_model.SemanticAllOf,
+				IdentityRoles: []string{"#" + dialSpRole},
+				ServiceRoles:  []string{"#" + svcAttrName},
+			},
+			{
+				Name:          u.scopedName("demo-server-bind"),
+				Type:          rest_model.DialBindBind,
+				Semantic:      rest_model.SemanticAllOf,
+				IdentityRoles: []string{"#" + bindSpRole},
+				ServiceRoles:  []string{"#" + svcAttrName},
+			},
+		},
+		// instance scoped router policies so the demo routes on controllers without permissive defaults
+		EdgeRouterPolicies: []manage.EdgeRouterPolicySpec{
+			{
+				Name:            u.scopedName("demo-edge-routers"),
+				Semantic:        rest_model.SemanticAnyOf,
+				IdentityRoles:   []string{"#" + dialSpRole, "#" + bindSpRole},
+				EdgeRouterRoles: edgeRouterRoles(),
+			},
+		},
+		ServiceEdgeRouterPolicies: []manage.ServiceEdgeRouterPolicySpec{
+			{
+				Name:            u.scopedName("demo-service-edge-routers"),
+				Semantic:        rest_model.SemanticAnyOf,
+				ServiceRoles:    []string{"#" + svcAttrName},
+				EdgeRouterRoles: edgeRouterRoles(),
+			},
+		},
+		Identities: []manage.IdentitySpec{
+			// an identity can only be enrolled once so the server identity is recreated when it has to be enrolled again
+			{Name: svrId, Type: rest_model.IdentityTypeDevice, RoleAttributes: []string{bindSpRole, "classifier-clients"}, Recreate: reenroll},
+		},
+	}
+	if PostureServiceEnabled() {
+		u.addPostureService(&desired, bindSpRole, u.scopedName("demo-service-edge-routers"), dialSpRole)
+	}
+	if u.oidc != nil {
+		if err := u.addOIDC(ctx, &desired); err != nil {
+			return manage.DesiredState{}, err
+		}
+	}
+	return desired, nil
+}
+
+// edgeRouterRoles are the edge routers the demo may use, read from the comma separated
+// OPENZITI_EDGE_ROUTER_ROLES. defaults to every edge router
+func edgeRouterRoles() []string {
+	var roles []string
+	for _, r := range strings.Split(os.Getenv("OPENZITI_EDGE_ROUTER_ROLES"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) == 0 {
+		return []string{"#all"}
+	}
+	return roles
 }
 
 func (u Server) HttpServiceName() string {
@@ -69,7 +230,25 @@ func (u Server) ReflectServiceName() string {
 	return u.scopedName("reflectService")
 }
 
+// httpInterceptPort is the port tunnelers intercept the http service on
+const httpInterceptPort = 80
+
+// HttpInterceptAddress is the hostname tunnelers intercept for the http service. it is
+// OPENZITI_INTERCEPT_DOMAIN (appetizer.ziti by default), prefixed with the instance unless this is prod
+func (u Server) HttpInterceptAddress() string {
+	domain := os.Getenv("OPENZITI_INTERCEPT_DOMAIN")
+	if domain == "" {
+		domain = "appetizer.ziti"
+	}
+	if u.instanceIdentifier == "" {
+		return domain
+	}
+	return strings.ToLower(u.instanceIdentifier) + "." + domain
+}
+
 func (u Server) Start() {
+	go u.reaper.Run(context.Background())
+
 	mux := http.NewServeMux()
 	mux.Handle("/add-me-to-openziti", http.HandlerFunc(u.addToOpenZiti))
 	mux.Handle("/taste", http.HandlerFunc(u.addToOpenZiti))
@@ -79,6 +258,22 @@ func (u Server) Start() {
 	mux.Handle("/getinvite", http.HandlerFunc(u.inviteHandler))
 	mux.Handle("/sample", http.HandlerFunc(u.sample))
 	mux.Handle("/meta", http.HandlerFunc(u.meta))
+	mux.Handle("/metrics", http.HandlerFunc(u.metrics))
+	if provisionToken() != "" {
+		mux.Handle("/admin/provision", http.HandlerFunc(u.provision))
+	}
+	if u.oidc != nil {
+		mux.Handle("/oidc/login", http.HandlerFunc(u.oidcLoginHandler))
+		mux.Handle("/oidc/callback", http.HandlerFunc(u.oidcCallback))
+		if u.oidc.cfg.Mock {
+			idp, err := mockidp.New(u.oidc.cfg.Issuer)
+			if err != nil {
+				logrus.Fatalf("could not start the mock identity provider: %v", err)
+			}
+			logrus.Warnf("serving a mock identity provider at %s. it signs in anyone", u.oidc.cfg.Issuer)
+			mux.Handle(mockIdPPath+"/", http.StripPrefix(mockIdPPath, idp))
+		}
+	}
 	mux.Handle("/", http.FileServer(http.Dir("http_content")))
 
 	// Get the current working directory
@@ -91,8 +286,13 @@ func (u Server) Start() {
 	// Print the working directory
 	logrus.Infof("current working directory: %s", wd)
 
+	//if set, will try to use LetsEncrypt to self-bootstrap TLS. __MUST__ listen on 443 if set
+	domainName := os.Getenv("OPENZITI_DOMAIN")
+
+	handler := u.withRequester(mux)
 	var svr *http.Server
-	if manage.DomainName != "" {
+	if domainName != "" {
+		logrus.Infof("domain name is not empty. server will try to bootstrap TLS for domain [%s] on port 443 (required 443)", domainName)
 		certmagic.DefaultACME.Agreed = true
 		email := os.Getenv("OPENZITI_ACME_EMAIL")
 		certmagic.DefaultACME.Email = email
@@ -105,28 +305,28 @@ func (u Server) Start() {
 			certmagic.DefaultACME.CA = certmagic.LetsEncryptProductionCA
 		}
 
-		err := certmagic.HTTPS([]string{manage.DomainName}, mux)
+		err := certmagic.HTTPS([]string{domainName}, handler)
 		if err != nil {
 			log.Fatalf("Failed to create https: %v", err)
 		}
-		ln, err := certmagic.Listen([]string{manage.DomainName})
+		ln, err := certmagic.Listen([]string{domainName})
 		if err != nil {
 			log.Fatalf("Failed to create listener: %v", err)
 		}
-		tlsConfig, err := certmagic.TLS([]string{manage.DomainName})
+		tlsConfig, err := certmagic.TLS([]string{domainName})
 		if err != nil {
 			log.Fatalf("Failed to create TLS: %v", err)
 		}
 		svr = &http.Server{
 			TLSConfig: tlsConfig,
 		}
-		svr.Handler = mux
+		svr.Handler = handler
 		if err := svr.ServeTLS(ln, "", ""); err != nil {
 			logrus.Fatal(err)
 		}
 	} else {
 		svr = &http.Server{}
-		svr.Handler = mux
+		svr.Handler = handler
 		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", 18000))
 		if err != nil {
 			logrus.Fatal(err)
@@ -186,6 +386,11 @@ func (u Server) inviteHandler(w http.ResponseWriter, r *http.Request) {
 const suf = "_taste"
 
 func (u Server) addToOpenZiti(w http.ResponseWriter, r *http.Request) {
+	// with a login provider, visitors are named by who they log in as rather than what they type
+	if u.oidc != nil {
+		http.Redirect(w, r, "/oidc/login?"+url.Values{"enrollment": {r.FormValue("enrollment")}}.Encode(), http.StatusSeeOther)
+		return
+	}
 	var name string
 	taster := r.URL.Query().Get("taste")
 	if taster == "" {
@@ -225,9 +430,27 @@ func (u Server) addToOpenZiti(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	enrollment, err := visitorEnrollment(r.FormValue("enrollment"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u.createVisitor(w, r, name, enrollment)
+}
+
+// createVisitor replaces the visitor identity with the visitor's name with a new one and shows how to use it.
+// a name taken by an identity visitors didn't create, such as a workshop identity, is a conflict
+func (u Server) createVisitor(w http.ResponseWriter, r *http.Request, name string, enrollment manage.Enrollment) {
 	name = u.scopedName(name)
-	manage.DeleteIdentity(name)
-	createdIdentity := manage.CreateIdentity(rest_model.IdentityTypeUser, name, &rest_model.Attributes{u.scopedName("demo.clients")})
+	if err := u.ctrl.DeleteOwnedIdentity(r.Context(), u.origin(manage.ComponentVisitor), name); err != nil {
+		writeManageError(w, err)
+		return
+	}
+	createdIdentity, err := u.ctrl.CreateIdentity(r.Context(), u.origin(manage.ComponentVisitor), rest_model.IdentityTypeUser, name, &rest_model.Attributes{u.scopedName("demo.clients")}, enrollment)
+	if err != nil {
+		writeManageError(w, err)
+		return
+	}
 
 	tmpl, err := template.ParseFiles("http_content/add-to-openziti-response.html")
 	if err != nil {
@@ -235,15 +458,27 @@ func (u Server) addToOpenZiti(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	data := struct {
-		Token      string
-		Name       string
-		HttpSvc    string
-		ReflectSvc string
+		Token       string
+		Name        string
+		HttpSvc     string
+		HttpAddress string
+		ReflectSvc  string
+		PostureSvc  string
+		// PostureChecks are what a visitor needs to pass to dial PostureSvc
+		PostureChecks []string
+		// Enrollment is the method the downloaded token enrolls with: ott, updb or ottca
+		Enrollment string
 	}{
-		Token:      createdIdentity.Payload.Data.ID,
-		Name:       name,
-		HttpSvc:    u.HttpServiceName(),
-		ReflectSvc: u.ReflectServiceName(),
+		Token:       *createdIdentity.ID,
+		Name:        name,
+		Enrollment:  string(enrollment.Method),
+		HttpSvc:     u.HttpServiceName(),
+		HttpAddress: u.HttpInterceptAddress(),
+		ReflectSvc:  u.ReflectServiceName(),
+	}
+	if PostureServiceEnabled() {
+		data.PostureSvc = u.PostureServiceName()
+		data.PostureChecks = postureRequirements()
 	}
 	err = tmpl.Execute(w, data)
 	if err != nil {
@@ -260,6 +495,42 @@ func (u Server) scopedName(name string) string {
 	}
 }
 
+// withRequester attributes the controller changes a request makes to the client that sent it
+func (u Server) withRequester(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := manage.WithRequester(r.Context(), manage.Requester{
+			ClientIP:     ip,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+			Instance:     u.instanceTag(),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Instance is the name of the demo instance, prod for the unscoped instance
+func (u Server) Instance() string {
+	return u.instanceTag()
+}
+
+// instanceTag is the instance name objects are tagged with. the unscoped instance is tagged as prod
+func (u Server) instanceTag() string {
+	if u.instanceIdentifier == "" {
+		return "prod"
+	}
+	return u.instanceIdentifier
+}
+
+func (u Server) origin(component string) manage.Origin {
+	return manage.Origin{
+		Instance:  u.instanceTag(),
+		Component: component,
+	}
+}
+
 func (u Server) downloadToken(w http.ResponseWriter, r *http.Request) {
 	t := r.URL.Query().Get("token")
 	if t == "" {
@@ -267,19 +538,27 @@ func (u Server) downloadToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := manage.FindIdentityDetail(t)
-	jwtToken := id.Data.Enrollment.Ott.JWT
-	if jwtToken == "" {
-		http.Error(w, "Token not available", http.StatusBadRequest)
+	id, err := u.ctrl.FindIdentityDetail(r.Context(), t)
+	if err != nil {
+		writeManageError(w, err)
 		return
 	}
-
-	w.Header().Set("Content-Disposition", "attachment; filename="+*id.Data.Name+".jwt")
-	w.Header().Set("Content-Type", "text/plain")
-	_, _ = w.Write([]byte(jwtToken))
+	writeEnrollmentToken(w, id)
 }
 
 func (u Server) sse(w http.ResponseWriter, r *http.Request) {
+	room, err := ParseRoom(r.URL.Query().Get("room"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// following a room opens it, so the page waits for the first message instead of asking again and again
+	topic, err := u.rooms.Room(room)
+	if err != nil {
+		http.Error(w, "Service Unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -289,49 +568,134 @@ func (u Server) sse(w http.ResponseWriter, r *http.Request) {
 	w.(http.Flusher).Flush() // Flush the headers to the client
 
 	id, _ := common.GenerateRandomID(10)
-	te := u.topic.NewEntry(id)
+	te := topic.NewEntry(id, u.overflow, topic.ParseEventID(lastEventID(r)))
 
 	for {
 		select {
-		case msg := <-te.Messages: //<-time.After(1 * time.Second):
-			_, _ = fmt.Fprintf(w, "%s", msg)
+		case msg, ok := <-te.Messages: //<-time.After(1 * time.Second):
+			if !ok {
+				logrus.Debugf("ending the event stream of %s. %d messages were dropped", id, te.Dropped())
+				return
+			}
+			if err := writeChatEvent(w, topic.EventID(msg), msg.Value); err != nil {
+				logrus.Errorf("could not write chat event %d: %v", msg.ID, err)
+				continue
+			}
 			w.(http.Flusher).Flush() // Flush the response to the client
 		case <-r.Context().Done():
-			u.topic.RemoveReceiver(te)
+			topic.RemoveReceiver(te)
 			logrus.Debug("client closed connection.")
 			return
 		}
 	}
 }
 
+// lastEventID is the id of the last event the browser saw, from the Last-Event-ID header EventSource sends
+// when it reconnects, or the lastEventId query parameter of a page that opens a new EventSource itself
+func lastEventID(r *http.Request) string {
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
 func (u Server) sample(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := visitorEnrollment(r.URL.Query().Get("enrollment"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	name := u.scopedName(common.GetRandomName())
-	manage.DeleteIdentity(name)
-	createdIdentity := manage.CreateIdentity(rest_model.IdentityTypeUser, name, &rest_model.Attributes{u.scopedName("demo.clients")})
-
-	t := createdIdentity.Payload.Data.ID
-	if t == "" {
-		http.Error(w, "Token not available", http.StatusBadRequest)
+	if err := u.ctrl.DeleteOwnedIdentity(r.Context(), u.origin(manage.ComponentVisitor), name); err != nil {
+		writeManageError(w, err)
 		return
 	}
+	createdIdentity, err := u.ctrl.CreateIdentity(r.Context(), u.origin(manage.ComponentVisitor), rest_model.IdentityTypeUser, name, &rest_model.Attributes{u.scopedName("demo.clients")}, enrollment)
+	if err != nil {
+		writeManageError(w, err)
+		return
+	}
+	writeEnrollmentToken(w, createdIdentity)
+}
 
-	id := manage.FindIdentityDetail(t)
-	jwtToken := id.Data.Enrollment.Ott.JWT
-	if jwtToken == "" {
+// writeEnrollmentToken sends the enrollment JWT of the identity as a file download. the JWT names its
+// enrollment method, so the same file works for ott, updb and ottca enrollment
+func writeEnrollmentToken(w http.ResponseWriter, id *rest_model.IdentityDetail) {
+	method, jwt, pending := manage.PendingEnrollment(id)
+	if !pending {
 		http.Error(w, "Token not available", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+*id.Data.Name+".jwt")
+	w.Header().Set("Content-Disposition", "attachment; filename="+*id.Name+".jwt")
 	w.Header().Set("Content-Type", "text/plain")
-	_, _ = w.Write([]byte(jwtToken))
+	w.Header().Set("X-Enrollment-Method", string(method))
+	_, _ = w.Write([]byte(jwt))
+}
+
+// writeManageError maps an error from the manage package to an HTTP status so a controller
+// failure only affects the request that caused it
+func writeManageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, manage.ErrNotFound):
+		logrus.Warnf("controller object not found: %v", err)
+		http.Error(w, "Not Found: the requested identity does not exist.", http.StatusNotFound)
+	case errors.Is(err, context.DeadlineExceeded):
+		logrus.Errorf("controller request timed out: %v", err)
+		http.Error(w, "Gateway Timeout: the OpenZiti controller did not respond in time.", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		logrus.Debugf("client went away before the controller request completed: %v", err)
+	case errors.Is(err, manage.ErrConflict):
+		logrus.Warnf("controller object already exists: %v", err)
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+	case errors.Is(err, manage.ErrUnavailable):
+		logrus.Errorf("controller unavailable: %v", err)
+		http.Error(w, "Service Unavailable: the OpenZiti controller is busy, please try again shortly.", http.StatusServiceUnavailable)
+	default:
+		logrus.Errorf("controller request failed: %v", err)
+		http.Error(w, "Bad Gateway: the OpenZiti controller could not complete the request.", http.StatusBadGateway)
+	}
 }
 
 func (u Server) meta(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{"qualifier": u.instanceIdentifier}
+	// every value is a string so clients decoding /meta into a map of strings keep working
+	var methods []string
+	for _, m := range enrollmentMethods() {
+		methods = append(methods, string(m))
+	}
+	response := map[string]string{
+		"qualifier":         u.instanceIdentifier,
+		"enrollmentMethods": strings.Join(methods, ","),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
 	_ = json.NewEncoder(w).Encode(response)
 }
+
+func (u Server) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	type roomMetrics struct {
+		Subscribers             []SubscriberStats `json:"subscribers"`
+		SubscribersDisconnected int64             `json:"subscribersDisconnected"`
+	}
+	rooms := map[string]roomMetrics{}
+	for _, name := range u.rooms.Names() {
+		if topic, found := u.rooms.Lookup(name); found {
+			rooms[name] = roomMetrics{
+				Subscribers:             topic.Subscribers(),
+				SubscribersDisconnected: topic.Disconnected(),
+			}
+		}
+	}
+	_ = json.NewEncoder(w).Encode(struct {
+		ReaperMetrics
+		Rooms map[string]roomMetrics `json:"rooms"`
+	}{
+		ReaperMetrics: u.reaper.Metrics(),
+		Rooms:         rooms,
+	})
+}
@@ -0,0 +1,364 @@
+package underlay
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/openziti/sdk-golang/ziti"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/manage/managetest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// repoRoot is where the server finds http_content
+var repoRoot, _ = filepath.Abs("..")
+
+// newTestServer returns a server for the test instance backed by an in-memory controller. the server
+// identity is saved to a temporary directory
+func newTestServer(t *testing.T) (Server, *managetest.Controller) {
+	t.Helper()
+	t.Chdir(repoRoot)
+	t.Setenv("OPENZITI_SERVER_IDENTITY_FILE", filepath.Join(t.TempDir(), "server.json"))
+	rooms := NewRooms[ChatEvent](0)
+	t.Cleanup(rooms.Close)
+	c, ctrl := managetest.NewClient()
+	return NewUnderlayServer(rooms, "test", c), ctrl
+}
+
+func identityNamed(ctrl *managetest.Controller, name string) *rest_model.IdentityDetail {
+	for _, i := range ctrl.Identities() {
+		if *i.Name == name {
+			return i
+		}
+	}
+	return nil
+}
+
+// createWorkshopIdentity provisions a workshop identity the way an organizer would
+func createWorkshopIdentity(t *testing.T, u Server, name string) {
+	t.Helper()
+	if _, err := u.Provision(context.Background(), []string{name}, manage.Enrollment{Method: manage.EnrollmentOTT}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	ctx := context.Background()
+
+	cfg, err := u.Prepare(ctx, "appetizer-server", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services []string
+	for _, s := range ctrl.Services() {
+		services = append(services, *s.Name)
+	}
+	slices.Sort(services)
+	if want := []string{"test_httpService", "test_reflectService"}; !slices.Equal(services, want) {
+		t.Errorf("created services %v, expected %v", services, want)
+	}
+	if len(ctrl.ServicePolicies()) != 2 {
+		t.Errorf("created %d service policies, expected the dial and bind policies", len(ctrl.ServicePolicies()))
+	}
+	server := identityNamed(ctrl, "test_appetizer-server")
+	if server == nil {
+		t.Fatal("the server identity was not created")
+	}
+	if server.Enrollment != nil && server.Enrollment.Ott != nil {
+		t.Error("the server identity was not enrolled")
+	}
+
+	if _, err := os.Stat(os.Getenv("OPENZITI_SERVER_IDENTITY_FILE")); err != nil {
+		t.Errorf("the server identity was not saved: %v", err)
+	}
+
+	// a restart reuses the saved identity and finds nothing to change
+	plan, err := u.Plan(ctx, "appetizer-server", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Errorf("planned %v for a network that is up to date", plan.Changes)
+	}
+	again, err := u.Prepare(ctx, "appetizer-server", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID.Cert != cfg.ID.Cert || *identityNamed(ctrl, "test_appetizer-server").ID != *server.ID {
+		t.Error("the saved server identity was not reused")
+	}
+}
+
+// unreachable is a controller that can't be reached to verify credentials
+type unreachable struct {
+	*managetest.Controller
+}
+
+func (unreachable) Authenticate(context.Context, *ziti.Config) (*rest_model.IdentityDetail, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func TestSavedServerIdentityIsOnlyReplacedWhenRejected(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	ctx := context.Background()
+	if _, err := u.Prepare(ctx, "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+	server := *identityNamed(ctrl, "test_appetizer-server").ID
+
+	offline := NewUnderlayServer(NewRooms[ChatEvent](0), "test", manage.NewClientWithAPI(managetest.Address, unreachable{ctrl}))
+	if _, err := offline.Prepare(ctx, "appetizer-server", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("preparing while the controller is unreachable returned %v", err)
+	}
+	if *identityNamed(ctrl, "test_appetizer-server").ID != server {
+		t.Fatal("the server identity was replaced while the controller was unreachable")
+	}
+
+	// the controller no longer knows the saved identity so it is enrolled again
+	if err := ctrl.DeleteIdentity(context.Background(), server); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Prepare(ctx, "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+	if replaced := identityNamed(ctrl, "test_appetizer-server"); replaced == nil || *replaced.ID == server {
+		t.Error("the rejected server identity was not enrolled again")
+	}
+}
+
+func TestHttpServiceConfigs(t *testing.T) {
+	t.Setenv("OPENZITI_INTERCEPT_DOMAIN", "demo.example")
+	u, ctrl := newTestServer(t)
+	if _, err := u.Prepare(context.Background(), "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := map[string]string{}
+	for _, cfg := range ctrl.Configs() {
+		configs[*cfg.ID] = fmt.Sprintf("%s %v", *cfg.Name, cfg.Data)
+	}
+	for _, s := range ctrl.Services() {
+		var names []string
+		for _, id := range s.Configs {
+			names = append(names, configs[id])
+		}
+		switch *s.Name {
+		case "test_httpService":
+			if len(names) != 2 || !strings.Contains(names[0], "test.demo.example") || !strings.HasPrefix(names[1], "test_httpService.host.v1") {
+				t.Errorf("the http service has the configs %v, expected an intercept of test.demo.example and a host config", names)
+			}
+		case "test_reflectService":
+			if len(names) != 0 {
+				t.Errorf("the reflect service has the configs %v", names)
+			}
+		}
+	}
+}
+
+func TestRouterPolicies(t *testing.T) {
+	t.Setenv("OPENZITI_EDGE_ROUTER_ROLES", "#east, #west,")
+	u, ctrl := newTestServer(t)
+	if _, err := u.Prepare(context.Background(), "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"#east", "#west"}
+	erps := ctrl.EdgeRouterPolicies()
+	if len(erps) != 1 || !slices.Equal(erps[0].EdgeRouterRoles, want) {
+		t.Errorf("created the edge router policies %v, expected one for %v", erps, want)
+	}
+	serps := ctrl.ServiceEdgeRouterPolicies()
+	if len(serps) != 1 || !slices.Equal(serps[0].EdgeRouterRoles, want) {
+		t.Errorf("created the service edge router policies %v, expected one for %v", serps, want)
+	}
+}
+
+func addMe(u Server, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/add-me-to-openziti", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	u.addToOpenZiti(w, r)
+	return w
+}
+
+func TestAddToOpenZiti(t *testing.T) {
+	u, ctrl := newTestServer(t)
+
+	w := addMe(u, url.Values{"name": {"amy"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding amy returned %d: %s", w.Code, w.Body)
+	}
+	amy := identityNamed(ctrl, "test_amy")
+	if amy == nil {
+		t.Fatal("no identity was created for amy")
+	}
+	if !strings.Contains(w.Body.String(), "/download-token?token="+*amy.ID) {
+		t.Error("the response does not link to amy's token")
+	}
+
+	// choosing the same name again replaces the visitor's identity
+	if w := addMe(u, url.Values{"name": {"amy"}}); w.Code != http.StatusOK {
+		t.Fatalf("adding amy again returned %d: %s", w.Code, w.Body)
+	}
+	if again := identityNamed(ctrl, "test_amy"); again == nil || *again.ID == *amy.ID {
+		t.Error("amy's identity was not replaced")
+	}
+
+	taste := base64.RawStdEncoding.EncodeToString([]byte("bea" + suf))
+	r := httptest.NewRequest(http.MethodGet, "/taste?taste="+taste, nil)
+	w = httptest.NewRecorder()
+	u.addToOpenZiti(w, r)
+	if w.Code != http.StatusOK || identityNamed(ctrl, "test_bea") == nil {
+		t.Errorf("an invite for bea returned %d", w.Code)
+	}
+
+	if w := addMe(u, url.Values{}); w.Code != http.StatusBadRequest {
+		t.Errorf("adding no name returned %d", w.Code)
+	}
+	if w := addMe(u, url.Values{"name": {"cam"}, "enrollment": {"smoke-signals"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("an unknown enrollment method returned %d", w.Code)
+	}
+}
+
+func TestVisitorCannotReplaceWorkshopIdentity(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	createWorkshopIdentity(t, u, "bob")
+	bob := identityNamed(ctrl, "test_bob")
+
+	if w := addMe(u, url.Values{"name": {"bob"}}); w.Code != http.StatusConflict {
+		t.Errorf("taking a workshop identity's name returned %d, expected %d", w.Code, http.StatusConflict)
+	}
+	if kept := identityNamed(ctrl, "test_bob"); kept == nil || *kept.ID != *bob.ID {
+		t.Error("the workshop identity was replaced")
+	}
+}
+
+func TestSampleAndDownloadToken(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	ctx := context.Background()
+
+	w := httptest.NewRecorder()
+	u.sample(w, httptest.NewRequest(http.MethodGet, "/sample", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("a sample identity returned %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("X-Enrollment-Method") != string(manage.EnrollmentOTT) || !strings.HasPrefix(w.Body.String(), "managetest.") {
+		t.Errorf("expected a one-time token, got %s %q", w.Header().Get("X-Enrollment-Method"), w.Body)
+	}
+	sample := ctrl.Identities()[0]
+
+	download := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		u.downloadToken(w, httptest.NewRequest(http.MethodGet, "/download-token?"+url.Values{"token": {token}}.Encode(), nil))
+		return w
+	}
+	w = download(*sample.ID)
+	if w.Code != http.StatusOK || w.Body.String() != sample.Enrollment.Ott.JWT {
+		t.Errorf("downloading the sample's token returned %d %q", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), *sample.Name+".jwt") {
+		t.Errorf("the token is downloaded as %s", w.Header().Get("Content-Disposition"))
+	}
+
+	if _, err := u.ctrl.EnrollIdentity(ctx, *sample.Name); err != nil {
+		t.Fatal(err)
+	}
+	if w := download(*sample.ID); w.Code != http.StatusBadRequest {
+		t.Errorf("downloading the token of an enrolled identity returned %d", w.Code)
+	}
+	if w := download("missing"); w.Code != http.StatusNotFound {
+		t.Errorf("downloading the token of a missing identity returned %d", w.Code)
+	}
+	if w := download(""); w.Code != http.StatusBadRequest {
+		t.Errorf("downloading without a token returned %d", w.Code)
+	}
+}
+
+func TestFollowingARoomOpensIt(t *testing.T) {
+	u, _ := newTestServer(t)
+	// a browser that already went away, so the event stream ends right after it starts
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	u.sse(w, httptest.NewRequest(http.MethodGet, "/sse?room=nobody-here", nil).WithContext(gone))
+	if w.Code != http.StatusOK {
+		t.Errorf("following a room no one joined returned %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	u.sse(w, httptest.NewRequest(http.MethodGet, "/sse?room=Not+A+Room!", nil).WithContext(gone))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("following an invalid room returned %d", w.Code)
+	}
+	u.metrics(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := u.rooms.Names(); !slices.Equal(got, []string{DefaultRoom, "nobody-here"}) {
+		t.Errorf("open rooms are %v, expected the lobby and the followed room", got)
+	}
+
+	for i := len(u.rooms.Names()); i < maxRooms; i++ {
+		if _, err := u.rooms.Room(fmt.Sprintf("room-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w = httptest.NewRecorder()
+	u.sse(w, httptest.NewRequest(http.MethodGet, "/sse?room=one-too-many", nil).WithContext(gone))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("following a room beyond the limit returned %d", w.Code)
+	}
+}
+
+func TestWriteManageError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: manage.ErrNotFound, want: http.StatusNotFound},
+		{err: manage.ErrConflict, want: http.StatusConflict},
+		{err: manage.ErrUnavailable, want: http.StatusServiceUnavailable},
+		{err: context.DeadlineExceeded, want: http.StatusGatewayTimeout},
+		{err: errors.New("boom"), want: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeManageError(w, tt.err)
+		if w.Code != tt.want {
+			t.Errorf("%v returned %d, expected %d", tt.err, w.Code, tt.want)
+		}
+	}
+}
+
+func TestVisitorChangesAreAudited(t *testing.T) {
+	u, _ := newTestServer(t)
+	var buf bytes.Buffer
+	u.ctrl.SetAuditLog(manage.NewAuditLog(&buf))
+
+	r := httptest.NewRequest(http.MethodPost, "/add-me-to-openziti", strings.NewReader(url.Values{"name": {"amy"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.RemoteAddr = "203.0.113.7:41000"
+	w := httptest.NewRecorder()
+	u.withRequester(http.HandlerFunc(u.addToOpenZiti)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding amy returned %d: %s", w.Code, w.Body)
+	}
+
+	var ev manage.AuditEvent
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Name != "test_amy" || ev.ClientIP != "203.0.113.7" || ev.ForwardedFor != "198.51.100.1" || ev.Instance != "test" {
+		t.Errorf("adding amy was logged as %+v", ev)
+	}
+}
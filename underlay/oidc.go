@@ -0,0 +1,374 @@
+package underlay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"openziti-test-kitchen/appetizer/clients/common"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcCookie    = "appetizer_oidc"
+	oidcLoginTTL  = 10 * time.Minute
+	mockIdPPath   = "/mock-idp"
+	mockIdPIssuer = "http://localhost:18000" + mockIdPPath
+)
+
+// OIDCConfig configures visitors logging in with an OpenID Connect provider instead of typing a name
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends visitors back to, /oidc/callback on the host they used when empty
+	RedirectURL string
+	// JwksURL is where the controller fetches the provider's keys, discovered from the issuer when empty
+	JwksURL string
+	Scopes  []string
+	// Mock serves a provider at /mock-idp that signs in anyone, for trying the login locally
+	Mock bool
+}
+
+// OIDCConfigFromEnv reads the OPENZITI_OIDC_* variables. OPENZITI_OIDC_MOCK defaults the issuer and
+// client to the mock provider
+func OIDCConfigFromEnv() OIDCConfig {
+	cfg := OIDCConfig{
+		Issuer:       os.Getenv("OPENZITI_OIDC_ISSUER"),
+		ClientID:     os.Getenv("OPENZITI_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OPENZITI_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OPENZITI_OIDC_REDIRECT_URL"),
+		JwksURL:      os.Getenv("OPENZITI_OIDC_JWKS_URL"),
+		Scopes:       []string{"openid"},
+	}
+	cfg.Mock, _ = strconv.ParseBool(os.Getenv("OPENZITI_OIDC_MOCK"))
+	if cfg.Mock {
+		if cfg.Issuer == "" {
+			cfg.Issuer = mockIdPIssuer
+		}
+		if cfg.ClientID == "" {
+			cfg.ClientID = "appetizer"
+		}
+		if cfg.JwksURL == "" {
+			cfg.JwksURL = strings.TrimSuffix(cfg.Issuer, "/") + "/jwks"
+		}
+	}
+	for _, s := range strings.Split(os.Getenv("OPENZITI_OIDC_SCOPES"), ",") {
+		if s = strings.TrimSpace(s); s != "" && s != "openid" {
+			cfg.Scopes = append(cfg.Scopes, s)
+		}
+	}
+	return cfg
+}
+
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+// providerMetadata is the part of the provider's discovery document the login needs
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// oidcLogin runs the authorization code flow against the provider and verifies the id tokens it returns
+type oidcLogin struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keys     *jose.JSONWebKeySet
+}
+
+func newOIDCLogin(cfg OIDCConfig) *oidcLogin {
+	return &oidcLogin{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *oidcLogin) discover(ctx context.Context) (*providerMetadata, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.metadata != nil {
+		return o.metadata, nil
+	}
+	var m providerMetadata
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("could not discover the oidc provider %s: %w", o.cfg.Issuer, err)
+	}
+	if m.Issuer != o.cfg.Issuer {
+		return nil, fmt.Errorf("oidc provider %s claims to be %s", o.cfg.Issuer, m.Issuer)
+	}
+	o.metadata = &m
+	return o.metadata, nil
+}
+
+// jwksURL is where the controller fetches the keys that sign the provider's tokens
+func (o *oidcLogin) jwksURL(ctx context.Context) (string, error) {
+	if o.cfg.JwksURL != "" {
+		return o.cfg.JwksURL, nil
+	}
+	m, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return m.JwksURI, nil
+}
+
+// signingKeys returns the provider's keys, fetching them again when refresh is set because a key was missing
+func (o *oidcLogin) signingKeys(ctx context.Context, refresh bool) (*jose.JSONWebKeySet, error) {
+	m, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.keys != nil && !refresh {
+		return o.keys, nil
+	}
+	var keys jose.JSONWebKeySet
+	if err := o.getJSON(ctx, m.JwksURI, &keys); err != nil {
+		return nil, fmt.Errorf("could not fetch the oidc provider keys: %w", err)
+	}
+	o.keys = &keys
+	return o.keys, nil
+}
+
+func (o *oidcLogin) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// authURL is where the visitor is sent to log in
+func (o *oidcLogin) authURL(ctx context.Context, redirect string, state string, nonce string) (string, error) {
+	m, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", o.cfg.ClientID)
+	q.Set("redirect_uri", redirect)
+	q.Set("scope", strings.Join(o.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// exchange redeems the authorization code and returns the verified subject of the id token
+func (o *oidcLogin) exchange(ctx context.Context, code string, redirect string, nonce string) (string, error) {
+	m, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirect},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not redeem the authorization code: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("could not read the token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("the oidc provider refused the authorization code: %s %s", resp.Status, tokens.Error)
+	}
+	return o.verify(ctx, tokens.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, lifetime and nonce of an id token and returns its subject
+func (o *oidcLogin) verify(ctx context.Context, idToken string, nonce string) (string, error) {
+	tok, err := jwt.ParseSigned(idToken, []jose.SignatureAlgorithm{jose.RS256, jose.RS384, jose.RS512, jose.ES256, jose.ES384, jose.ES512})
+	if err != nil {
+		return "", fmt.Errorf("could not parse the id token: %w", err)
+	}
+	keys, err := o.signingKeys(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	// providers rotate keys, so an unknown key id is worth one more look at the key set
+	if len(tok.Headers) > 0 && len(keys.Key(tok.Headers[0].KeyID)) == 0 {
+		if keys, err = o.signingKeys(ctx, true); err != nil {
+			return "", err
+		}
+	}
+
+	var claims jwt.Claims
+	var extra struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := tok.Claims(keys, &claims, &extra); err != nil {
+		return "", fmt.Errorf("could not verify the id token: %w", err)
+	}
+	if err := claims.Validate(jwt.Expected{Issuer: o.cfg.Issuer, AnyAudience: jwt.Audience{o.cfg.ClientID}, Time: time.Now()}); err != nil {
+		return "", fmt.Errorf("the id token is not valid: %w", err)
+	}
+	if extra.Nonce != nonce {
+		return "", errors.New("the id token was issued for another login")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("the id token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// redirectURL is the callback the provider sends the visitor back to
+func (o *oidcLogin) redirectURL(r *http.Request) string {
+	if o.cfg.RedirectURL != "" {
+		return o.cfg.RedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/oidc/callback"
+}
+
+// oidcLoginHandler sends the visitor to the provider, remembering the login in a short-lived cookie
+func (u Server) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	enrollment := r.FormValue("enrollment")
+	if _, err := visitorEnrollment(enrollment); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	state, _ := common.GenerateRandomID(24)
+	nonce, _ := common.GenerateRandomID(24)
+	target, err := u.oidc.authURL(r.Context(), u.oidc.redirectURL(r), state, nonce)
+	if err != nil {
+		logrus.Errorf("could not start an oidc login: %v", err)
+		http.Error(w, "Bad Gateway: the login provider could not be reached.", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    url.Values{"state": {state}, "nonce": {nonce}, "enrollment": {enrollment}}.Encode(),
+		Path:     "/oidc/",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// oidcCallback finishes the login and gives the visitor an identity bound to their verified subject
+func (u Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		http.Error(w, "Bad Request: the login expired, please try again.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/oidc/", MaxAge: -1})
+	login, err := url.ParseQuery(cookie.Value)
+	if err != nil || login.Get("state") == "" || login.Get("state") != r.URL.Query().Get("state") {
+		http.Error(w, "Bad Request: the login does not match this browser, please try again.", http.StatusBadRequest)
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		logrus.Warnf("oidc login failed: %s %s", e, r.URL.Query().Get("error_description"))
+		http.Error(w, "Unauthorized: the login provider did not sign you in.", http.StatusUnauthorized)
+		return
+	}
+
+	subject, err := u.oidc.exchange(r.Context(), r.URL.Query().Get("code"), u.oidc.redirectURL(r), login.Get("nonce"))
+	if err != nil {
+		logrus.Warnf("oidc login failed: %v", err)
+		http.Error(w, "Unauthorized: the login could not be verified.", http.StatusUnauthorized)
+		return
+	}
+	enrollment, err := visitorEnrollment(login.Get("enrollment"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	authPolicy, err := u.ctrl.AuthPolicyID(r.Context(), u.oidcAuthPolicyName())
+	if err != nil {
+		writeManageError(w, err)
+		return
+	}
+	enrollment.ExternalID = subject
+	enrollment.AuthPolicyID = authPolicy
+	logrus.Infof("oidc visitor signed in: %s", subject)
+
+	// a subject has a single identity, so logging in again replaces it
+	if err := u.ctrl.DeleteIdentityByExternalID(r.Context(), u.origin(manage.ComponentVisitor), subject); err != nil {
+		writeManageError(w, err)
+		return
+	}
+	u.createVisitor(w, r, subject, enrollment)
+}
+
+func (u Server) oidcSignerName() string {
+	return u.scopedName("demo-oidc")
+}
+
+func (u Server) oidcAuthPolicyName() string {
+	return u.scopedName("demo-oidc-visitors")
+}
+
+// addOIDC adds the external jwt signer that trusts the provider and the auth policy visitors who logged in
+// with it get. they keep certificate and password authentication for the enrollment they choose
+func (u Server) addOIDC(ctx context.Context, desired *manage.DesiredState) error {
+	jwks, err := u.oidc.jwksURL(ctx)
+	if err != nil {
+		return err
+	}
+	desired.ExternalJWTSigners = append(desired.ExternalJWTSigners, manage.ExternalJWTSignerSpec{
+		Name:            u.oidcSignerName(),
+		Issuer:          u.oidc.cfg.Issuer,
+		Audience:        u.oidc.cfg.ClientID,
+		JwksEndpoint:    jwks,
+		UseExternalID:   true,
+		ClientID:        u.oidc.cfg.ClientID,
+		Scopes:          u.oidc.cfg.Scopes,
+		ExternalAuthURL: u.oidc.cfg.Issuer,
+	})
+	desired.AuthPolicies = append(desired.AuthPolicies, manage.AuthPolicySpec{
+		Name:          u.oidcAuthPolicyName(),
+		AllowCert:     true,
+		AllowUpdb:     true,
+		ExtJWTSigners: []string{u.oidcSignerName()},
+	})
+	return nil
+}
@@ -0,0 +1,279 @@
+package underlay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/openziti/edge-api/rest_model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openziti-test-kitchen/appetizer/manage"
+	"openziti-test-kitchen/appetizer/mockidp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMockIdP serves a mock identity provider and returns the login of a client of it
+func startMockIdP(t *testing.T) *oidcLogin {
+	t.Helper()
+	var idp http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	p, err := mockidp.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp = p
+	return newOIDCLogin(OIDCConfig{Issuer: srv.URL, ClientID: "appetizer", Scopes: []string{"openid"}})
+}
+
+// signIn logs subject in at the provider the way a browser would and returns where the provider sends it back to
+func signIn(t *testing.T, authURL string, subject string) *url.URL {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(authURL, url.Values{"login": {subject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	back, err := resp.Location()
+	if err != nil {
+		t.Fatalf("the provider did not send the visitor back: %s", resp.Status)
+	}
+	return back
+}
+
+func TestOIDCExchange(t *testing.T) {
+	o := startMockIdP(t)
+	ctx := context.Background()
+	redirect := "http://appetizer.example/oidc/callback"
+
+	login := func(nonce string) string {
+		authURL, err := o.authURL(ctx, redirect, "state", nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signIn(t, authURL, "amy").Query().Get("code")
+	}
+
+	code := login("nonce")
+	subject, err := o.exchange(ctx, code, redirect, "nonce")
+	if err != nil || subject != "amy" {
+		t.Fatalf("signed in as %q: %v", subject, err)
+	}
+	if _, err := o.exchange(ctx, code, redirect, "nonce"); err == nil {
+		t.Error("an authorization code was redeemed twice")
+	}
+	if _, err := o.exchange(ctx, login("nonce"), redirect, "another login"); err == nil {
+		t.Error("an id token issued for another login was accepted")
+	}
+	if _, err := o.exchange(ctx, login("nonce"), "http://elsewhere.example/oidc/callback", "nonce"); err == nil {
+		t.Error("a code was redeemed for another redirect")
+	}
+}
+
+// signer signs id tokens with a key the tests control
+type signer struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newSigner(t *testing.T, kid string) signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{key: key, kid: kid}
+}
+
+func (s signer) sign(t *testing.T, claims jwt.Claims, nonce string) string {
+	t.Helper()
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: s.key}, (&jose.SignerOptions{}).WithHeader("kid", s.kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(sig).Claims(claims).Claims(map[string]interface{}{"nonce": nonce}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestOIDCVerify(t *testing.T) {
+	o := startMockIdP(t)
+	ctx := context.Background()
+	// the provider's keys, as if they had been fetched from it
+	trusted := newSigner(t, "trusted")
+	if _, err := o.discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	o.keys = &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &trusted.key.PublicKey, KeyID: "trusted", Algorithm: string(jose.RS256), Use: "sig"}}}
+
+	valid := func() jwt.Claims {
+		now := time.Now()
+		return jwt.Claims{
+			Issuer:   o.cfg.Issuer,
+			Subject:  "amy",
+			Audience: jwt.Audience{"appetizer"},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	}
+	if subject, err := o.verify(ctx, trusted.sign(t, valid(), "n"), "n"); err != nil || subject != "amy" {
+		t.Fatalf("a valid token was verified as %q: %v", subject, err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{name: "unknown key", token: func() string { return newSigner(t, "mockidp").sign(t, valid(), "n") }},
+		{name: "forged key id", token: func() string { return newSigner(t, "trusted").sign(t, valid(), "n") }},
+		{name: "other issuer", token: func() string {
+			c := valid()
+			c.Issuer = "https://evil.example"
+			return trusted.sign(t, c, "n")
+		}},
+		{name: "other audience", token: func() string {
+			c := valid()
+			c.Audience = jwt.Audience{"someone-else"}
+			return trusted.sign(t, c, "n")
+		}},
+		{name: "expired", token: func() string {
+			c := valid()
+			c.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+			return trusted.sign(t, c, "n")
+		}},
+		{name: "no subject", token: func() string {
+			c := valid()
+			c.Subject = ""
+			return trusted.sign(t, c, "n")
+		}},
+		{name: "other nonce", token: func() string { return trusted.sign(t, valid(), "other") }},
+		{name: "tampered", token: func() string {
+			parts := strings.Split(trusted.sign(t, valid(), "n"), ".")
+			other := strings.Split(trusted.sign(t, jwt.Claims{Issuer: o.cfg.Issuer, Subject: "admin", Audience: jwt.Audience{"appetizer"}}, "n"), ".")
+			return parts[0] + "." + other[1] + "." + parts[2]
+		}},
+		{name: "not a token", token: func() string { return "amy" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if subject, err := o.verify(ctx, tt.token(), "n"); err == nil {
+				t.Errorf("signed in as %q", subject)
+			}
+		})
+	}
+}
+
+// oidcServer returns a test server whose visitors log in with the mock identity provider
+func oidcServer(t *testing.T) Server {
+	t.Helper()
+	u, _ := newTestServer(t)
+	u.oidc = startMockIdP(t)
+	ctx := context.Background()
+	desired, err := u.desiredState(ctx, u.scopedName("appetizer-server"), false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.ctrl.Reconcile(ctx, desired); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// oidcLoginAs runs the whole login of subject through the server and returns the callback's response
+func oidcLoginAs(t *testing.T, u Server, subject string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	u.oidcLoginHandler(w, httptest.NewRequest(http.MethodGet, "http://appetizer.example/oidc/login?enrollment=ott", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("starting the login returned %d: %s", w.Code, w.Body)
+	}
+	back := signIn(t, w.Header().Get("Location"), subject)
+
+	r := httptest.NewRequest(http.MethodGet, back.String(), nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	u.oidcCallback(w, r)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	u := oidcServer(t)
+	ctx := context.Background()
+
+	if w := oidcLoginAs(t, u, "amy"); w.Code != http.StatusOK {
+		t.Fatalf("logging in returned %d: %s", w.Code, w.Body)
+	}
+	amy, err := u.ctrl.FindIdentity(ctx, "test_amy")
+	if err != nil || amy == "" {
+		t.Fatalf("no identity was created for amy: %v", err)
+	}
+	detail, err := u.ctrl.FindIdentityDetail(ctx, amy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, _ := u.ctrl.AuthPolicyID(ctx, u.oidcAuthPolicyName())
+	if detail.ExternalID == nil || *detail.ExternalID != "amy" || detail.AuthPolicyID == nil || *detail.AuthPolicyID != policy {
+		t.Error("amy's identity is not bound to the login")
+	}
+
+	// logging in again replaces the identity
+	if w := oidcLoginAs(t, u, "amy"); w.Code != http.StatusOK {
+		t.Fatalf("logging in again returned %d: %s", w.Code, w.Body)
+	}
+	if again, _ := u.ctrl.FindIdentity(ctx, "test_amy"); again == amy {
+		t.Error("amy's identity was not replaced")
+	}
+
+	// another instance's identity bound to the same subject is left alone
+	other := manage.Origin{Instance: "test_b", Component: manage.ComponentVisitor}
+	bob := manage.Enrollment{Method: manage.EnrollmentOTT, ExternalID: "bob"}
+	if _, err := u.ctrl.CreateIdentity(ctx, other, rest_model.IdentityTypeUser, "test_b_bob", nil, bob); err != nil {
+		t.Fatal(err)
+	}
+	if w := oidcLoginAs(t, u, "bob"); w.Code != http.StatusConflict {
+		t.Errorf("logging in as the subject of another instance's identity returned %d", w.Code)
+	}
+
+	createWorkshopIdentity(t, u, "cam")
+	if w := oidcLoginAs(t, u, "cam"); w.Code != http.StatusConflict {
+		t.Errorf("logging in as a workshop identity's name returned %d", w.Code)
+	}
+}
+
+func TestOIDCCallbackChecksTheBrowser(t *testing.T) {
+	u := oidcServer(t)
+
+	w := httptest.NewRecorder()
+	u.oidcCallback(w, httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state=y", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("a callback without a login returned %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state=y", nil)
+	r.AddCookie(&http.Cookie{Name: oidcCookie, Value: url.Values{"state": {"z"}, "nonce": {"n"}}.Encode()})
+	w = httptest.NewRecorder()
+	u.oidcCallback(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("a callback for another browser's login returned %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/oidc/callback?code=x&state=y", nil)
+	r.AddCookie(&http.Cookie{Name: oidcCookie, Value: url.Values{"state": {"y"}, "nonce": {"n"}}.Encode()})
+	w = httptest.NewRecorder()
+	u.oidcCallback(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("a made up code returned %d", w.Code)
+	}
+}
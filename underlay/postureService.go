@@ -0,0 +1,87 @@
+package underlay
+
+import (
+	"github.com/openziti/edge-api/rest_model"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+	"strconv"
+)
+
+// desktopOperatingSystems are the operating systems allowed to dial the posture service
+var desktopOperatingSystems = []rest_model.OsType{rest_model.OsTypeWindows, rest_model.OsTypeMacOS, rest_model.OsTypeLinux}
+
+// PostureServiceEnabled reports whether OPENZITI_POSTURE_SERVICE asks for the posture checked demo service
+func PostureServiceEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("OPENZITI_POSTURE_SERVICE"))
+	return enabled
+}
+
+// postureRequiresMFA reports whether OPENZITI_POSTURE_REQUIRE_MFA adds an mfa check to the posture service
+func postureRequiresMFA() bool {
+	required, _ := strconv.ParseBool(os.Getenv("OPENZITI_POSTURE_REQUIRE_MFA"))
+	return required
+}
+
+func (u Server) PostureServiceName() string {
+	return u.scopedName("postureService")
+}
+
+// addPostureService adds the posture checked service, the checks, the dial policy requiring them and a bind
+// policy for the server. the service has its own role attribute so the regular dial policy doesn't grant it
+// without the checks. the regular bind policy is AllOf, so the attribute can't simply be added to it
+func (u Server) addPostureService(desired *manage.DesiredState, bindSpRole string, serp string, dialSpRole string) {
+	svcAttrName := u.scopedName("demo-posture-services")
+	checkAttrName := u.scopedName("demo-posture-checks")
+
+	var systems []*rest_model.OperatingSystem
+	for _, t := range desktopOperatingSystems {
+		osType := t
+		systems = append(systems, &rest_model.OperatingSystem{Type: &osType, Versions: []string{}})
+	}
+	desired.PostureChecks = append(desired.PostureChecks, manage.PostureCheckSpec{
+		Name:             u.scopedName("demo-posture-os"),
+		RoleAttributes:   []string{checkAttrName},
+		OperatingSystems: systems,
+	})
+	if postureRequiresMFA() {
+		desired.PostureChecks = append(desired.PostureChecks, manage.PostureCheckSpec{
+			Name:           u.scopedName("demo-posture-mfa"),
+			RoleAttributes: []string{checkAttrName},
+			MFA:            &rest_model.PostureCheckMfaProperties{},
+		})
+	}
+
+	desired.Services = append(desired.Services, manage.ServiceSpec{
+		Name: u.PostureServiceName(), RoleAttributes: []string{svcAttrName}, EncryptionRequired: true,
+	})
+	desired.ServicePolicies = append(desired.ServicePolicies, manage.ServicePolicySpec{
+		Name:              u.scopedName("demo-posture-dial"),
+		Type:              rest_model.DialBindDial,
+		Semantic:          rest_model.SemanticAllOf,
+		IdentityRoles:     []string{"#" + dialSpRole},
+		ServiceRoles:      []string{"#" + svcAttrName},
+		PostureCheckRoles: []string{"#" + checkAttrName},
+	})
+	desired.ServicePolicies = append(desired.ServicePolicies, manage.ServicePolicySpec{
+		Name:          u.scopedName("demo-posture-bind"),
+		Type:          rest_model.DialBindBind,
+		Semantic:      rest_model.SemanticAllOf,
+		IdentityRoles: []string{"#" + bindSpRole},
+		ServiceRoles:  []string{"#" + svcAttrName},
+	})
+	// the service edge router policy is AnyOf, adding the attribute lets the routers carry the service too
+	for i := range desired.ServiceEdgeRouterPolicies {
+		if desired.ServiceEdgeRouterPolicies[i].Name == serp {
+			desired.ServiceEdgeRouterPolicies[i].ServiceRoles = append(desired.ServiceEdgeRouterPolicies[i].ServiceRoles, "#"+svcAttrName)
+		}
+	}
+}
+
+// postureRequirements describes the checks a visitor has to pass to dial the posture service
+func postureRequirements() []string {
+	requirements := []string{"the dialing device runs Windows, macOS or Linux"}
+	if postureRequiresMFA() {
+		requirements = append(requirements, "the identity has enrolled and completed multi-factor authentication")
+	}
+	return requirements
+}
@@ -0,0 +1,75 @@
+package underlay
+
+import (
+	"context"
+	"github.com/openziti/edge-api/rest_model"
+	"slices"
+	"testing"
+)
+
+func TestPostureServicePolicies(t *testing.T) {
+	t.Setenv("OPENZITI_POSTURE_SERVICE", "true")
+	u, ctrl := newTestServer(t)
+	if _, err := u.Prepare(context.Background(), "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+
+	policies := map[string]*rest_model.ServicePolicyDetail{}
+	for _, p := range ctrl.ServicePolicies() {
+		policies[*p.Name] = p
+	}
+	tests := []struct {
+		name         string
+		serviceRoles []string
+		checkRoles   []string
+	}{
+		// the bind policy is AllOf, another service role would keep the server from binding any service
+		{name: "test_demo-server-bind", serviceRoles: []string{"#test_demo-services"}},
+		{name: "test_demo-server-dial", serviceRoles: []string{"#test_demo-services"}},
+		{name: "test_demo-posture-bind", serviceRoles: []string{"#test_demo-posture-services"}},
+		{name: "test_demo-posture-dial", serviceRoles: []string{"#test_demo-posture-services"}, checkRoles: []string{"#test_demo-posture-checks"}},
+	}
+	for _, tt := range tests {
+		p := policies[tt.name]
+		if p == nil {
+			t.Errorf("the service policy %s was not created", tt.name)
+			continue
+		}
+		if *p.Semantic != rest_model.SemanticAllOf || !slices.Equal(p.ServiceRoles, tt.serviceRoles) || !slices.Equal(p.PostureCheckRoles, tt.checkRoles) {
+			t.Errorf("%s is %s of the services %v with the posture checks %v, expected AllOf of %v with %v",
+				tt.name, *p.Semantic, p.ServiceRoles, p.PostureCheckRoles, tt.serviceRoles, tt.checkRoles)
+		}
+	}
+	if bind := policies["test_demo-posture-bind"]; bind != nil && !slices.Equal(bind.IdentityRoles, []string{"#test_demo.servers"}) {
+		t.Errorf("the posture service is bound by %v", bind.IdentityRoles)
+	}
+
+	serps := ctrl.ServiceEdgeRouterPolicies()
+	if len(serps) != 1 || !slices.Contains(serps[0].ServiceRoles, "#test_demo-posture-services") {
+		t.Errorf("the posture service can't be reached through the edge routers: %v", serps)
+	}
+	if checks := ctrl.PostureChecks(); len(checks) != 1 {
+		t.Errorf("created %d posture checks, expected the operating system check", len(checks))
+	}
+}
+
+func TestPostureServiceRequiringMFA(t *testing.T) {
+	t.Setenv("OPENZITI_POSTURE_SERVICE", "true")
+	t.Setenv("OPENZITI_POSTURE_REQUIRE_MFA", "true")
+	u, ctrl := newTestServer(t)
+	if _, err := u.Prepare(context.Background(), "appetizer-server", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, c := range ctrl.PostureChecks() {
+		names = append(names, *c.Name())
+	}
+	slices.Sort(names)
+	if want := []string{"test_demo-posture-mfa", "test_demo-posture-os"}; !slices.Equal(names, want) {
+		t.Errorf("created the posture checks %v, expected %v", names, want)
+	}
+	if len(postureRequirements()) != 2 {
+		t.Errorf("visitors are told about %v", postureRequirements())
+	}
+}
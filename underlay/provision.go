@@ -0,0 +1,189 @@
+package underlay
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/openziti/edge-api/rest_model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// maxProvisionNames caps how many identities one request provisions
+	maxProvisionNames = 500
+	// maxProvisionBody caps the size of the uploaded list of names
+	maxProvisionBody = 1 << 20
+)
+
+// provisionToken is the bearer token the bulk provisioning endpoint requires, from OPENZITI_PROVISION_TOKEN.
+// the endpoint is not served when it is empty
+func provisionToken() string {
+	return os.Getenv("OPENZITI_PROVISION_TOKEN")
+}
+
+// ParseNames reads identity names from the first column of a CSV. blank lines, lines starting with #
+// and a header row whose first column is name are skipped. a name listed twice is returned once
+func ParseNames(r io.Reader) ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var names []string
+	seen := map[string]bool{}
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read the list of names: %w", err)
+		}
+		name := strings.TrimSpace(record[0])
+		if name == "" || seen[name] || (first && strings.EqualFold(name, "name")) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("the list of names is empty")
+	}
+	if len(names) > maxProvisionNames {
+		return nil, fmt.Errorf("%d names were listed, at most %d can be provisioned at once", len(names), maxProvisionNames)
+	}
+	return names, nil
+}
+
+// Provision makes sure there is a workshop identity for every name, scoped to this instance. the
+// identities can use the demo services like visitors do, but are not reaped
+func (u Server) Provision(ctx context.Context, names []string, enrollment manage.Enrollment) ([]manage.ProvisionedIdentity, error) {
+	scoped := make([]string, len(names))
+	for i, name := range names {
+		scoped[i] = u.scopedName(name)
+	}
+	return u.ctrl.ProvisionIdentities(ctx, u.origin(manage.ComponentWorkshop), scoped, &rest_model.Attributes{u.scopedName("demo.clients")}, enrollment)
+}
+
+// ProvisionEnrollment returns the enrollment for provisioned identities, see visitorEnrollment
+func ProvisionEnrollment(method string) (manage.Enrollment, error) {
+	return visitorEnrollment(method)
+}
+
+// WriteProvisionZip writes a zip holding the enrollment JWT of every provisioned identity that has yet to
+// enroll, and a manifest.csv listing each identity, how it enrolls and whether it was just created
+func WriteProvisionZip(w io.Writer, provisioned []manage.ProvisionedIdentity) error {
+	files := tokenFileNames(provisioned)
+	zw := zip.NewWriter(w)
+	now := time.Now()
+
+	manifest, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.csv", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(manifest)
+	_ = cw.Write([]string{"name", "method", "status", "file"})
+	for i, p := range provisioned {
+		_ = cw.Write([]string{p.Name, string(p.Method), string(p.Status), files[i]})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	for i, p := range provisioned {
+		if p.JWT == "" {
+			continue
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: files[i], Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, p.JWT); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// tokenFileNames names the file the JWT of each identity with a token is stored in. anything but letters,
+// digits, dashes and underscores is replaced so names can't escape the folder the zip is extracted to, and
+// names that end up the same are numbered
+func tokenFileNames(provisioned []manage.ProvisionedIdentity) []string {
+	files := make([]string, len(provisioned))
+	taken := map[string]bool{}
+	for i, p := range provisioned {
+		if p.JWT == "" {
+			continue
+		}
+		base := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+				return r
+			}
+			return '_'
+		}, p.Name)
+		file := base + ".jwt"
+		for n := 2; taken[file]; n++ {
+			file = fmt.Sprintf("%s-%d.jwt", base, n)
+		}
+		taken[file] = true
+		files[i] = file
+	}
+	return files
+}
+
+// authorizedToProvision reports whether the request carries the provisioning token as a bearer token
+func authorizedToProvision(r *http.Request) bool {
+	token := provisionToken()
+	presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && found && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// provision creates a workshop identity for every name in the CSV posted as the request body and responds
+// with a zip of their enrollment tokens
+func (u Server) provision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizedToProvision(r) {
+		logrus.Warnf("rejected an unauthorized provisioning request from %s", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="appetizer"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	enrollment, err := ProvisionEnrollment(r.URL.Query().Get("enrollment"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	names, err := ParseNames(http.MaxBytesReader(w, r.Body, maxProvisionBody))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	provisioned, err := u.Provision(r.Context(), names, enrollment)
+	if err != nil {
+		writeManageError(w, err)
+		return
+	}
+	logrus.Infof("provisioned %d workshop identities", len(provisioned))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", u.scopedName("workshop-identities")+".zip"))
+	if err := WriteProvisionZip(w, provisioned); err != nil {
+		logrus.Errorf("could not write the provisioned identities: %v", err)
+	}
+}
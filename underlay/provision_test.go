@@ -0,0 +1,139 @@
+package underlay
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openziti-test-kitchen/appetizer/manage"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseNames(t *testing.T) {
+	names, err := ParseNames(strings.NewReader("name,email\n# the morning session\namy,amy@example.com\n\n  bob \namy\n\"cam, jr\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"amy", "bob", "cam, jr"}; !slices.Equal(names, want) {
+		t.Errorf("parsed %q, expected %q", names, want)
+	}
+
+	if _, err := ParseNames(strings.NewReader("name\n# nobody yet\n")); err == nil {
+		t.Error("a list without names was accepted")
+	}
+	if _, err := ParseNames(strings.NewReader("\"amy\n")); err == nil {
+		t.Error("a malformed list was accepted")
+	}
+
+	var list strings.Builder
+	for i := range maxProvisionNames {
+		fmt.Fprintf(&list, "visitor-%d\n", i)
+	}
+	if names, err := ParseNames(strings.NewReader(list.String())); err != nil || len(names) != maxProvisionNames {
+		t.Errorf("%d names could not be provisioned: %v", maxProvisionNames, err)
+	}
+	list.WriteString("one-too-many\n")
+	if _, err := ParseNames(strings.NewReader(list.String())); err == nil {
+		t.Errorf("more than %d names were accepted", maxProvisionNames)
+	}
+}
+
+func TestTokenFileNames(t *testing.T) {
+	provisioned := []manage.ProvisionedIdentity{
+		{Name: "test_amy", JWT: "a"},
+		{Name: "test_../../etc/passwd", JWT: "b"},
+		{Name: "test_bob", Status: manage.ProvisionEnrolled},
+		{Name: "test_cam jr", JWT: "c"},
+		{Name: "test_cam/jr", JWT: "d"},
+	}
+	want := []string{"test_amy.jwt", "test_______etc_passwd.jwt", "", "test_cam_jr.jwt", "test_cam_jr-2.jwt"}
+	if got := tokenFileNames(provisioned); !slices.Equal(got, want) {
+		t.Errorf("named the tokens %q, expected %q", got, want)
+	}
+}
+
+// readZip returns the files of the zip by name
+func readZip(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func provisionRequest(u Server, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/admin/provision?"+url.Values{"enrollment": {"ott"}}.Encode(), strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	u.provision(w, r)
+	return w
+}
+
+func TestProvision(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	t.Setenv("OPENZITI_PROVISION_TOKEN", "s3cret")
+
+	if w := provisionRequest(u, "", "amy\n"); w.Code != http.StatusUnauthorized {
+		t.Errorf("provisioning without the token returned %d", w.Code)
+	}
+	if w := provisionRequest(u, "guess", "amy\n"); w.Code != http.StatusUnauthorized {
+		t.Errorf("provisioning with the wrong token returned %d", w.Code)
+	}
+	if w := provisionRequest(u, "s3cret", "name\n"); w.Code != http.StatusBadRequest {
+		t.Errorf("provisioning an empty list returned %d", w.Code)
+	}
+
+	w := provisionRequest(u, "s3cret", "name\namy\nbob\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("provisioning returned %d: %s", w.Code, w.Body)
+	}
+	files := readZip(t, w.Body.Bytes())
+	amy := identityNamed(ctrl, "test_amy")
+	if amy == nil || files["test_amy.jwt"] != amy.Enrollment.Ott.JWT {
+		t.Errorf("the zip does not hold amy's token: %v", files)
+	}
+	manifest, err := csv.NewReader(strings.NewReader(files["manifest.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 3 || !slices.Equal(manifest[1], []string{"test_amy", "ott", "created", "test_amy.jwt"}) {
+		t.Errorf("the manifest is %q", manifest)
+	}
+
+	// provisioning the list again keeps the tokens already handed out
+	w = provisionRequest(u, "s3cret", "amy\nbob\n")
+	files = readZip(t, w.Body.Bytes())
+	if files["test_amy.jwt"] != amy.Enrollment.Ott.JWT || !strings.Contains(files["manifest.csv"], "test_amy,ott,pending") {
+		t.Errorf("provisioning again replaced amy's token: %v", files)
+	}
+
+	// a name a visitor already took is not handed to the workshop
+	if w := addMe(u, url.Values{"name": {"cam"}}); w.Code != http.StatusOK {
+		t.Fatalf("adding cam returned %d", w.Code)
+	}
+	if w := provisionRequest(u, "s3cret", "cam\n"); w.Code != http.StatusConflict {
+		t.Errorf("provisioning a visitor's name returned %d", w.Code)
+	}
+}
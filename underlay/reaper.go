@@ -0,0 +1,151 @@
+package underlay
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultVisitorTTL           = 24 * time.Hour
+	defaultUnenrolledVisitorTTL = time.Hour
+	defaultReapInterval         = 10 * time.Minute
+)
+
+// ReaperConfig controls how long visitor identities live
+type ReaperConfig struct {
+	// VisitorTTL is how long an enrolled visitor identity is kept
+	VisitorTTL time.Duration
+	// UnenrolledVisitorTTL is how long a visitor identity whose token was never used is kept
+	UnenrolledVisitorTTL time.Duration
+	// Interval is how often visitor identities are checked. zero disables the reaper
+	Interval time.Duration
+}
+
+// ReaperConfigFromEnv reads OPENZITI_VISITOR_TTL, OPENZITI_UNENROLLED_VISITOR_TTL and
+// OPENZITI_REAP_INTERVAL as durations (e.g. 24h), falling back to the defaults when unset or invalid
+func ReaperConfigFromEnv() ReaperConfig {
+	return ReaperConfig{
+		VisitorTTL:           durationFromEnv("OPENZITI_VISITOR_TTL", defaultVisitorTTL),
+		UnenrolledVisitorTTL: durationFromEnv("OPENZITI_UNENROLLED_VISITOR_TTL", defaultUnenrolledVisitorTTL),
+		Interval:             durationFromEnv("OPENZITI_REAP_INTERVAL", defaultReapInterval),
+	}
+}
+
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.Warnf("%s is not a valid duration (%s). using default of %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// Reaper deletes visitor identities once they outlive their TTL
+type Reaper struct {
+	ctrl   *manage.Client
+	origin manage.Origin
+	prefix string
+	cfg    ReaperConfig
+
+	reaped           atomic.Int64
+	reapedUnenrolled atomic.Int64
+	failures         atomic.Int64
+	lastRun          atomic.Int64
+}
+
+// ReaperMetrics counts the visitor identities reaped since the server started
+type ReaperMetrics struct {
+	VisitorsReaped           int64     `json:"visitorsReaped"`
+	UnenrolledVisitorsReaped int64     `json:"unenrolledVisitorsReaped"`
+	ReapFailures             int64     `json:"reapFailures"`
+	LastRun                  time.Time `json:"lastRun,omitempty"`
+}
+
+// NewReaper reaps the visitors tagged with origin, and the untagged visitors named with prefix that
+// were created before tagging
+func NewReaper(ctrl *manage.Client, origin manage.Origin, prefix string, cfg ReaperConfig) *Reaper {
+	return &Reaper{
+		ctrl:   ctrl,
+		origin: origin,
+		prefix: prefix,
+		cfg:    cfg,
+	}
+}
+
+// Run reaps visitor identities every interval until ctx is done
+func (r *Reaper) Run(ctx context.Context) {
+	if r.cfg.Interval <= 0 {
+		logrus.Infof("visitor identity reaper disabled")
+		return
+	}
+	logrus.Infof("reaping visitor identities every %s. enrolled visitors live %s, unenrolled visitors live %s",
+		r.cfg.Interval, r.cfg.VisitorTTL, r.cfg.UnenrolledVisitorTTL)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Reap(ctx, time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reap deletes the visitor identities that expired by now
+func (r *Reaper) Reap(ctx context.Context, now time.Time) {
+	defer r.lastRun.Store(now.Unix())
+	ctx = manage.WithRequester(ctx, manage.Requester{Instance: r.origin.Instance, Source: "reaper"})
+
+	visitors, err := r.ctrl.FindVisitorIdentities(ctx, r.origin, r.prefix)
+	if err != nil {
+		r.failures.Add(1)
+		logrus.Errorf("could not list visitor identities to reap: %v", err)
+		return
+	}
+
+	for _, v := range visitors {
+		_, _, unenrolled := manage.PendingEnrollment(v)
+		ttl := r.cfg.VisitorTTL
+		if unenrolled {
+			ttl = r.cfg.UnenrolledVisitorTTL
+		}
+		// untagged visitors have no createdAt tag, CreatedAt falls back to when the controller created them
+		age := now.Sub(manage.CreatedAt(v.BaseEntity))
+		if ttl <= 0 || age < ttl {
+			continue
+		}
+
+		if err := r.ctrl.DeleteIdentityByID(ctx, *v.ID, *v.Name); err != nil {
+			r.failures.Add(1)
+			logrus.Errorf("could not reap visitor identity %s: %v", *v.Name, err)
+			continue
+		}
+		if unenrolled {
+			r.reapedUnenrolled.Add(1)
+		} else {
+			r.reaped.Add(1)
+		}
+		logrus.Infof("reaped visitor identity %s (age %s, enrolled: %t)", *v.Name, age.Round(time.Second), !unenrolled)
+	}
+}
+
+func (r *Reaper) Metrics() ReaperMetrics {
+	m := ReaperMetrics{
+		VisitorsReaped:           r.reaped.Load(),
+		UnenrolledVisitorsReaped: r.reapedUnenrolled.Load(),
+		ReapFailures:             r.failures.Load(),
+	}
+	if last := r.lastRun.Load(); last != 0 {
+		m.LastRun = time.Unix(last, 0).UTC()
+	}
+	return m
+}
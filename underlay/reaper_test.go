@@ -0,0 +1,113 @@
+package underlay
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/openziti/edge-api/rest_model"
+	"net/http"
+	"net/http/httptest"
+	"openziti-test-kitchen/appetizer/manage"
+	"testing"
+	"time"
+)
+
+func TestReap(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	ctx := context.Background()
+	ott := manage.Enrollment{Method: manage.EnrollmentOTT}
+	visitors := u.origin(manage.ComponentVisitor)
+	for _, name := range []string{"test_enrolled", "test_unenrolled"} {
+		if _, err := u.ctrl.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, name, nil, ott); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := u.ctrl.EnrollIdentity(ctx, "test_enrolled"); err != nil {
+		t.Fatal(err)
+	}
+	createWorkshopIdentity(t, u, "workshop")
+	if _, err := u.ctrl.CreateIdentity(ctx, u.origin(manage.ComponentNetwork), rest_model.IdentityTypeDevice, "test_server", nil, ott); err != nil {
+		t.Fatal(err)
+	}
+	// visitors and other identities created before tagging
+	userType := rest_model.IdentityTypeUser
+	for _, name := range []string{"test_randomizer_Ab3-x_9Z", "test_legacy"} {
+		if _, err := ctrl.CreateIdentity(ctx, &rest_model.IdentityCreate{Name: &name, Type: &userType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReaper(u.ctrl, visitors, u.scopedName(""), ReaperConfig{VisitorTTL: 24 * time.Hour, UnenrolledVisitorTTL: time.Hour})
+	now := time.Now()
+	r.Reap(ctx, now.Add(30*time.Minute))
+	if len(ctrl.Identities()) != 6 {
+		t.Errorf("identities were reaped before they expired")
+	}
+	r.Reap(ctx, now.Add(2*time.Hour))
+	if identityNamed(ctrl, "test_unenrolled") != nil || identityNamed(ctrl, "test_enrolled") == nil {
+		t.Errorf("only the unenrolled visitor should be reaped after its TTL")
+	}
+	if identityNamed(ctrl, "test_randomizer_Ab3-x_9Z") == nil {
+		t.Errorf("the untagged visitor was reaped before its TTL")
+	}
+	r.Reap(ctx, now.Add(25*time.Hour))
+	if identityNamed(ctrl, "test_enrolled") != nil {
+		t.Error("the enrolled visitor was not reaped after its TTL")
+	}
+	if identityNamed(ctrl, "test_randomizer_Ab3-x_9Z") != nil {
+		t.Error("the untagged visitor was not reaped after its TTL")
+	}
+	if identityNamed(ctrl, "test_legacy") == nil {
+		t.Error("an untagged identity that is not a visitor was reaped")
+	}
+	if identityNamed(ctrl, "test_server") == nil {
+		t.Error("an identity that is not a visitor was reaped")
+	}
+	if identityNamed(ctrl, "test_workshop") == nil {
+		t.Error("a workshop identity was reaped")
+	}
+
+	m := r.Metrics()
+	if m.VisitorsReaped != 2 || m.UnenrolledVisitorsReaped != 1 || m.ReapFailures != 0 {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+}
+
+func TestZeroTTLKeepsVisitors(t *testing.T) {
+	u, ctrl := newTestServer(t)
+	ctx := context.Background()
+	ott := manage.Enrollment{Method: manage.EnrollmentOTT}
+	visitors := u.origin(manage.ComponentVisitor)
+	if _, err := u.ctrl.CreateIdentity(ctx, visitors, rest_model.IdentityTypeUser, "test_amy", nil, ott); err != nil {
+		t.Fatal(err)
+	}
+	NewReaper(u.ctrl, visitors, u.scopedName(""), ReaperConfig{}).Reap(ctx, time.Now().Add(24*365*time.Hour))
+	if len(ctrl.Identities()) != 1 {
+		t.Error("a visitor was reaped although it should be kept forever")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	u, _ := newTestServer(t)
+	ctx := context.Background()
+	u.reaper.Reap(ctx, time.Now())
+
+	w := httptest.NewRecorder()
+	u.metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var m ReaperMetrics
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || m.LastRun.IsZero() {
+		t.Errorf("/metrics returned %d %+v", w.Code, m)
+	}
+}
+
+func TestReaperConfigFromEnv(t *testing.T) {
+	t.Setenv("OPENZITI_VISITOR_TTL", "2h")
+	t.Setenv("OPENZITI_UNENROLLED_VISITOR_TTL", "soon")
+	t.Setenv("OPENZITI_REAP_INTERVAL", "0")
+	want := ReaperConfig{VisitorTTL: 2 * time.Hour, UnenrolledVisitorTTL: defaultUnenrolledVisitorTTL}
+	if cfg := ReaperConfigFromEnv(); cfg != want {
+		t.Errorf("read %+v, expected %+v", cfg, want)
+	}
+}
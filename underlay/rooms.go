@@ -0,0 +1,115 @@
+package underlay
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultRoom is where chat messages go until the sender joins another room
+	DefaultRoom = "lobby"
+	// maxRooms caps how many rooms visitors can open, rooms are never removed
+	maxRooms = 64
+)
+
+var roomName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ParseRoom returns the room named by s, DefaultRoom when s is empty. names are lowercase letters, digits,
+// dashes and underscores, up to 32 long
+func ParseRoom(s string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if name == "" {
+		return DefaultRoom, nil
+	}
+	if !roomName.MatchString(name) {
+		return "", fmt.Errorf("%q is not a valid room name. use up to 32 letters, digits, dashes and underscores", s)
+	}
+	return name, nil
+}
+
+// Rooms is a set of named topics, each with its own subscribers and history. the lobby is always open, other
+// rooms are opened when someone joins them
+type Rooms[T any] struct {
+	historySize int
+
+	mu     sync.Mutex
+	topics map[string]*Topic[T]
+	closed bool
+}
+
+// NewRooms returns a set of rooms holding only the lobby, whose topics keep historySize messages
+func NewRooms[T any](historySize int) *Rooms[T] {
+	r := &Rooms[T]{
+		historySize: historySize,
+		topics:      map[string]*Topic[T]{},
+	}
+	r.open(DefaultRoom)
+	return r
+}
+
+// Room returns the topic of the room, opening the room if it doesn't exist yet
+func (r *Rooms[T]) Room(name string) (*Topic[T], error) {
+	name, err := ParseRoom(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, errors.New("the chat rooms are closed")
+	}
+	if t, found := r.topics[name]; found {
+		return t, nil
+	}
+	if len(r.topics) >= maxRooms {
+		return nil, fmt.Errorf("there are already %d rooms, join one of them instead", maxRooms)
+	}
+	return r.open(name), nil
+}
+
+// open starts the topic of a new room. the caller holds the lock or owns r
+func (r *Rooms[T]) open(name string) *Topic[T] {
+	t := NewTopic[T](r.historySize)
+	t.Start()
+	r.topics[name] = t
+	return t
+}
+
+// Lookup returns the topic of the open room with the given name, which is not opened if it doesn't exist.
+// use it where anyone can name a room, so they can't use up the rooms by naming new ones
+func (r *Rooms[T]) Lookup(name string) (*Topic[T], bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, found := r.topics[name]
+	return t, found
+}
+
+// Names returns the names of the open rooms, sorted
+func (r *Rooms[T]) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.topics))
+	for name := range r.topics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Close closes the topic of every room. no room can be opened afterwards
+func (r *Rooms[T]) Close() {
+	r.mu.Lock()
+	topics := r.topics
+	r.topics = map[string]*Topic[T]{}
+	r.closed = true
+	r.mu.Unlock()
+
+	for _, t := range topics {
+		t.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package underlay
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestRooms(t *testing.T) {
+	rooms := NewRooms[string](0)
+	defer rooms.Close()
+
+	if _, found := rooms.Lookup(DefaultRoom); !found {
+		t.Error("the lobby is not open")
+	}
+	if _, found := rooms.Lookup("workshop"); found {
+		t.Error("a room no one joined is open")
+	}
+	if _, err := rooms.Room("Not A Room!"); err == nil {
+		t.Error("opened a room with an invalid name")
+	}
+	if _, err := rooms.Room("workshop"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rooms.Names(); !slices.Equal(got, []string{DefaultRoom, "workshop"}) {
+		t.Errorf("open rooms are %v", got)
+	}
+	// a browser moving to another room can't skip its history with an id seen in the lobby
+	lobby, _ := rooms.Lookup(DefaultRoom)
+	workshop, _ := rooms.Lookup("workshop")
+	if seen := lobby.EventID(Event[string]{ID: 3}); workshop.ParseEventID(seen) != 0 {
+		t.Errorf("the workshop took the lobby's event id %s as its own", seen)
+	}
+	for i := len(rooms.Names()); i < maxRooms; i++ {
+		if _, err := rooms.Room(fmt.Sprintf("room-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rooms.Room("one-too-many"); err == nil {
+		t.Errorf("opened more than %d rooms", maxRooms)
+	}
+}
@@ -0,0 +1,58 @@
+package underlay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/openziti/sdk-golang/ziti"
+	"github.com/sirupsen/logrus"
+	"openziti-test-kitchen/appetizer/manage"
+	"os"
+)
+
+// serverIdentityFile is where the enrolled server identity is kept between restarts. it defaults to
+// <identity name>.json in the working directory and can be set with OPENZITI_SERVER_IDENTITY_FILE
+func serverIdentityFile(svrId string) string {
+	if f := os.Getenv("OPENZITI_SERVER_IDENTITY_FILE"); f != "" {
+		return f
+	}
+	return svrId + ".json"
+}
+
+// loadServerIdentity returns the saved server identity or nil when it is missing or the
+// controller no longer accepts it. any other failure to verify it is returned so a controller
+// that is briefly unreachable doesn't cost the server its identity
+func (u Server) loadServerIdentity(ctx context.Context, svrId string, idFile string) (*ziti.Config, error) {
+	if _, err := os.Stat(idFile); err != nil {
+		logrus.Infof("no saved server identity at %s. the server identity will be enrolled", idFile)
+		return nil, nil
+	}
+	cfg, err := ziti.NewConfigFromFile(idFile)
+	if err != nil {
+		logrus.Warnf("could not read the saved server identity at %s. the server identity will be enrolled again: %v", idFile, err)
+		return nil, nil
+	}
+	err = u.ctrl.VerifyIdentity(ctx, svrId, cfg)
+	switch {
+	case err == nil:
+		return cfg, nil
+	case errors.Is(err, manage.ErrUnauthorized), errors.Is(err, manage.ErrNotFound), errors.Is(err, manage.ErrIdentityMismatch):
+		logrus.Warnf("the saved server identity at %s was rejected. the server identity will be enrolled again: %v", idFile, err)
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("could not verify the saved server identity at %s: %w", idFile, err)
+	}
+}
+
+func saveServerIdentity(idFile string, cfg *ziti.Config) error {
+	output, err := os.OpenFile(idFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = output.Close() }()
+
+	enc := json.NewEncoder(output)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(cfg)
+}
@@ -1,12 +1,28 @@
 package underlay
 
-import "github.com/sirupsen/logrus"
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type Notifiable[T any] interface {
-	Notify(msg T)
+	Notify(ev Event[T])
 	Id() string
 }
 
+// Event is a message sent to a topic. ids increase by one with every message, starting at 1. browsers see
+// them qualified by the topic's epoch, see EventID
+type Event[T any] struct {
+	ID    uint64
+	Value T
+}
+
 type TopicEntries[T any] struct {
 	Id    string
 	Entry Notifiable[T]
@@ -18,51 +34,270 @@ const (
 	NOTIFY TopicActions = iota
 	ADD
 	REMOVE
+	STATS
 )
 
 type TopicAction[T any] struct {
 	action TopicActions
 	entry  Notifiable[T]
 	msg    T
+	reply  chan<- []SubscriberStats
+	// replay asks for the history after the event with id after to be sent to a new entry
+	replay bool
+	after  uint64
+}
+
+// OverflowPolicy is what happens to a message for a subscriber whose queue is full. the topic never
+// waits for a slow subscriber, so one stalled browser can't hold up the others
+type OverflowPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one
+	DropOldest OverflowPolicy = "drop-oldest"
+	// DropNewest discards the new message
+	DropNewest OverflowPolicy = "drop-newest"
+	// Disconnect removes the subscriber, closing its queue
+	Disconnect OverflowPolicy = "disconnect"
+)
+
+// ParseOverflowPolicy returns the policy named by s, defaulting to DropOldest when s is empty
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return DropOldest, nil
+	case DropOldest, DropNewest, Disconnect:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q, expected drop-oldest, drop-newest or disconnect", s)
+}
+
+const defaultHistorySize = 100
+
+// HistorySizeFromEnv returns how many messages a topic keeps for new subscribers, from OPENZITI_SSE_HISTORY
+func HistorySizeFromEnv() int {
+	v := os.Getenv("OPENZITI_SSE_HISTORY")
+	if v == "" {
+		return defaultHistorySize
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		logrus.Warnf("OPENZITI_SSE_HISTORY is not a valid number of messages (%s). using default of %d", v, defaultHistorySize)
+		return defaultHistorySize
+	}
+	return n
+}
+
+// OverflowPolicyFromEnv returns the policy for event stream subscribers from OPENZITI_SSE_OVERFLOW
+func OverflowPolicyFromEnv() OverflowPolicy {
+	v := os.Getenv("OPENZITI_SSE_OVERFLOW")
+	p, err := ParseOverflowPolicy(v)
+	if err != nil {
+		logrus.Warnf("OPENZITI_SSE_OVERFLOW is not a valid overflow policy (%s). using default of %s", v, DropOldest)
+		return DropOldest
+	}
+	return p
+}
+
+// SubscriberStats describes a receiver of a topic
+type SubscriberStats struct {
+	Id      string         `json:"id"`
+	Policy  OverflowPolicy `json:"policy,omitempty"`
+	Queued  int            `json:"queued"`
+	Dropped int64          `json:"dropped"`
 }
 
+// overflowing is implemented by entries the topic should remove after a message overflowed them
+type overflowing interface {
+	overflowed() bool
+}
+
+// closable is implemented by entries that want to know when the topic stops sending to them
+type closable interface {
+	close()
+}
+
+// Topic fans every message out to its receivers. a topic runs its own goroutine from Start until
+// Close, so any number of topics can run side by side
 type Topic[T any] struct {
 	entries []Notifiable[T]
 	actions chan TopicAction[T]
+	// done is closed by Close to stop the topic
+	done chan struct{}
+	// stopped is closed once the topic's goroutine has returned
+	stopped chan struct{}
+	start   sync.Once
+	stop    sync.Once
+	// sending holds senders off while Close marks the topic closed, so nothing is queued after the drain
+	sending sync.RWMutex
+	closed  bool
+	// disconnected counts the subscribers removed for falling behind
+	disconnected atomic.Int64
+	// lastID is the id of the latest message. only the topic's goroutine uses it
+	lastID  uint64
+	history *ring[Event[T]]
+	// epoch tells the ids of this topic apart from those of other topics and of earlier runs of the process,
+	// which start at 1 too
+	epoch string
 }
 
-var isRunning = false
+// processEpoch changes with every run of the process. topics add a number of their own to it
+var (
+	processEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	topicCount   atomic.Uint64
+)
 
-func (t *Topic[T]) Start() {
-	t.actions = make(chan TopicAction[T], 16)
-	t.entries = make([]Notifiable[T], 0, 64)
+// NewTopic returns a topic that delivers messages once it is started. it keeps the last historySize
+// messages for entries that join later
+func NewTopic[T any](historySize int) *Topic[T] {
+	return &Topic[T]{
+		entries: make([]Notifiable[T], 0, 64),
+		actions: make(chan TopicAction[T], 16),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		history: newRing[Event[T]](historySize),
+		epoch:   processEpoch + "." + strconv.FormatUint(topicCount.Add(1), 36),
+	}
+}
+
+// EventID is the id browsers see for the event: the topic's epoch and the event's id
+func (t *Topic[T]) EventID(ev Event[T]) string {
+	return t.epoch + "-" + strconv.FormatUint(ev.ID, 10)
+}
 
-	if !isRunning {
+// ParseEventID returns the id of the event a browser last saw. an id from another epoch, such as one
+// seen before the process restarted, or a malformed one is 0 so the browser is sent everything kept
+func (t *Topic[T]) ParseEventID(s string) uint64 {
+	epoch, id, found := strings.Cut(strings.TrimSpace(s), "-")
+	if !found || epoch != t.epoch {
+		return 0
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Start runs the goroutine delivering the topic's messages. starting a topic again does nothing
+func (t *Topic[T]) Start() {
+	t.start.Do(func() {
 		go t.processActions()
-		isRunning = true
+	})
+}
+
+// Close stops the topic and waits for its goroutine to return. receivers are closed and anything sent
+// to the topic from then on is dropped. a topic can't be started again once closed
+func (t *Topic[T]) Close() {
+	t.stop.Do(func() {
+		// senders waiting for room in the queue give up once done is closed
+		close(t.done)
+	})
+	t.sending.Lock()
+	t.closed = true
+	t.sending.Unlock()
+	// a topic that never started has no goroutine to wait for
+	t.start.Do(func() {
+		close(t.stopped)
+	})
+	<-t.stopped
+	t.drain()
+}
+
+// drain drops the actions still queued once the topic's goroutine is gone. receivers that were never
+// added are closed too, so nobody waits on them
+func (t *Topic[T]) drain() {
+	dropped := 0
+	for {
+		select {
+		case action := <-t.actions:
+			if action.action == ADD {
+				closeEntry(action.entry)
+			}
+			dropped++
+		default:
+			if dropped > 0 {
+				logrus.Debugf("topic closed. %d queued actions dropped", dropped)
+			}
+			return
+		}
 	}
 }
 
 func (t *Topic[T]) processActions() {
+	defer close(t.stopped)
 	for {
-		action := <-t.actions
-		switch action.action {
-		case ADD:
-			logrus.Debugf("adding entry: %s", action.entry.Id())
-			t.entries = append(t.entries, action.entry)
-			logrus.Infof("added entry: %s. size now: %d", action.entry.Id(), len(t.entries))
-			break
-		case REMOVE:
-			logrus.Debugf("removing entry: %s", action.entry.Id())
-			t.removeEntry(action.entry)
-			logrus.Infof("removed entry: %s. size now: %d", action.entry.Id(), len(t.entries))
-			break
-		case NOTIFY:
-			for _, n := range t.entries {
-				n.Notify(action.msg)
+		select {
+		case action := <-t.actions:
+			t.process(action)
+		case <-t.done:
+			for _, e := range t.entries {
+				closeEntry(e)
+			}
+			t.entries = nil
+			return
+		}
+	}
+}
+
+func (t *Topic[T]) process(action TopicAction[T]) {
+	switch action.action {
+	case ADD:
+		logrus.Debugf("adding entry: %s", action.entry.Id())
+		t.entries = append(t.entries, action.entry)
+		logrus.Infof("added entry: %s. size now: %d", action.entry.Id(), len(t.entries))
+		if action.replay {
+			t.replay(action.entry, action.after)
+		}
+	case REMOVE:
+		logrus.Debugf("removing entry: %s", action.entry.Id())
+		t.removeEntry(action.entry)
+		logrus.Infof("removed entry: %s. size now: %d", action.entry.Id(), len(t.entries))
+	case NOTIFY:
+		t.lastID++
+		ev := Event[T]{ID: t.lastID, Value: action.msg}
+		t.history.push(ev)
+		var slow []Notifiable[T]
+		for _, n := range t.entries {
+			n.Notify(ev)
+			if o, ok := n.(overflowing); ok && o.overflowed() {
+				slow = append(slow, n)
+			}
+		}
+		for _, n := range slow {
+			logrus.Warnf("disconnecting entry %s. it fell behind", n.Id())
+			t.removeEntry(n)
+			t.disconnected.Add(1)
+		}
+	case STATS:
+		stats := make([]SubscriberStats, 0, len(t.entries))
+		for _, n := range t.entries {
+			s := SubscriberStats{Id: n.Id()}
+			if e, ok := n.(*TopicEntry[T]); ok {
+				s.Policy = e.policy
+				s.Queued = len(e.Messages)
+				s.Dropped = e.Dropped()
 			}
-			break
+			stats = append(stats, s)
 		}
+		action.reply <- stats
+	}
+}
+
+// replay sends the entry the kept messages that came after the event with id after. an id the topic
+// hasn't reached yet was not sent by it, so everything kept is sent
+func (t *Topic[T]) replay(entry Notifiable[T], after uint64) {
+	if after > t.lastID {
+		after = 0
+	}
+	replayed := 0
+	t.history.each(func(ev Event[T]) {
+		if ev.ID > after {
+			entry.Notify(ev)
+			replayed++
+		}
+	})
+	if replayed > 0 {
+		logrus.Debugf("replayed %d messages to entry: %s", replayed, entry.Id())
 	}
 }
 
@@ -70,60 +305,188 @@ func (t *Topic[T]) removeEntry(action Notifiable[T]) {
 	for a, b := range t.entries {
 		if action.Id() == b.Id() {
 			t.entries = append(t.entries[:a], t.entries[a+1:]...)
+			closeEntry(b)
 			return
 		}
 	}
-	logrus.Warn("attempt to remove entry with id %s failed?", action.Id())
+	logrus.Warnf("attempt to remove entry with id %s failed?", action.Id())
 }
 
-func (t *Topic[T]) Close() {
-	close(t.actions)
-	isRunning = false
+func closeEntry[T any](e Notifiable[T]) {
+	if c, ok := e.(closable); ok {
+		c.close()
+	}
+}
+
+// send queues the action for the topic's goroutine. it reports false when the topic is closed and the
+// action was dropped
+func (t *Topic[T]) send(ta TopicAction[T]) bool {
+	t.sending.RLock()
+	defer t.sending.RUnlock()
+	if t.closed {
+		return false
+	}
+	select {
+	case t.actions <- ta:
+		return true
+	case <-t.done:
+		return false
+	}
 }
 
 func (t *Topic[T]) AddReceiver(entry Notifiable[T]) {
-	ta := TopicAction[T]{
+	if !t.send(TopicAction[T]{
 		action: ADD,
 		entry:  entry,
+	}) {
+		closeEntry(entry)
 	}
-	t.actions <- ta
 }
 
 func (t *Topic[T]) RemoveReceiver(entry Notifiable[T]) {
-	ta := TopicAction[T]{
+	t.send(TopicAction[T]{
 		action: REMOVE,
 		entry:  entry,
+	})
+}
+
+// Subscribers returns the topic's receivers with how many of their messages are queued and were dropped
+func (t *Topic[T]) Subscribers() []SubscriberStats {
+	reply := make(chan []SubscriberStats, 1)
+	t.send(TopicAction[T]{
+		action: STATS,
+		reply:  reply,
+	})
+	select {
+	case stats := <-reply:
+		return stats
+	case <-t.done:
+		return nil
 	}
-	t.actions <- ta
+}
+
+// Disconnected returns how many subscribers were removed for falling behind
+func (t *Topic[T]) Disconnected() int64 {
+	return t.disconnected.Load()
 }
 
 func (t *Topic[T]) Notify(m T) {
-	ta := TopicAction[T]{
+	t.send(TopicAction[T]{
 		action: NOTIFY,
 		entry:  nil,
 		msg:    m,
-	}
-	t.actions <- ta
+	})
 }
 
-func (t *Topic[T]) NewEntry(id string) *TopicEntry[T] {
+// NewEntry adds a receiver whose messages arrive on its Messages channel, starting with the kept messages
+// that came after the event with id lastEventID. 0 replays everything kept. the policy decides what
+// happens when the receiver falls behind. the channel is closed when the entry is removed or the topic closes
+func (t *Topic[T]) NewEntry(id string, policy OverflowPolicy, lastEventID uint64) *TopicEntry[T] {
 	e := &TopicEntry[T]{
 		identifier: id,
-		Messages:   make(chan T, 16),
+		// room for a full replay on top of the usual queue
+		Messages: make(chan Event[T], 16+t.history.size()),
+		policy:   policy,
+	}
+	if !t.send(TopicAction[T]{
+		action: ADD,
+		entry:  e,
+		replay: true,
+		after:  lastEventID,
+	}) {
+		// the topic is closed, the entry ends right away
+		e.close()
 	}
-	t.AddReceiver(e)
 	return e
 }
 
 type TopicEntry[T any] struct {
 	identifier string
-	Messages   chan T
+	Messages   chan Event[T]
+	policy     OverflowPolicy
+	dropped    atomic.Int64
+	// full is set when a message overflowed an entry whose policy is Disconnect
+	full bool
 }
 
 func (e *TopicEntry[T]) Id() string {
 	return e.identifier
 }
 
-func (e TopicEntry[T]) Notify(msg T) {
-	e.Messages <- msg
+// Notify queues the message without waiting. when the queue is full the entry's overflow policy applies
+func (e *TopicEntry[T]) Notify(ev Event[T]) {
+	if e.full {
+		return
+	}
+	for {
+		select {
+		case e.Messages <- ev:
+			return
+		default:
+		}
+		e.dropped.Add(1)
+		switch e.policy {
+		case DropNewest:
+			return
+		case Disconnect:
+			e.full = true
+			return
+		}
+		// drop the oldest message. the receiver may have taken it already, then there is room to try again
+		select {
+		case <-e.Messages:
+		default:
+			e.dropped.Add(-1)
+		}
+	}
+}
+
+// Dropped returns how many messages were dropped because the entry fell behind
+func (e *TopicEntry[T]) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+func (e *TopicEntry[T]) overflowed() bool {
+	return e.full
+}
+
+func (e *TopicEntry[T]) close() {
+	close(e.Messages)
+}
+
+// ring keeps the last values pushed to it, up to its size
+type ring[T any] struct {
+	values []T
+	// next is where the next value goes, overwriting the oldest once the ring is full
+	next int
+	full bool
+}
+
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{values: make([]T, max(size, 0))}
+}
+
+func (r *ring[T]) size() int {
+	return len(r.values)
+}
+
+func (r *ring[T]) push(v T) {
+	if len(r.values) == 0 {
+		return
+	}
+	r.values[r.next] = v
+	r.next = (r.next + 1) % len(r.values)
+	r.full = r.full || r.next == 0
+}
+
+// each calls f with every kept value, oldest first
+func (r *ring[T]) each(f func(T)) {
+	if r.full {
+		for _, v := range r.values[r.next:] {
+			f(v)
+		}
+	}
+	for _, v := range r.values[:r.next] {
+		f(v)
+	}
 }
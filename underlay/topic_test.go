@@ -0,0 +1,265 @@
+package underlay
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receive waits for the next message of the entry. ok is false when the channel was closed
+func receive(t *testing.T, e *TopicEntry[string]) (msg string, ok bool) {
+	t.Helper()
+	select {
+	case ev, ok := <-e.Messages:
+		return ev.Value, ok
+	case <-time.After(time.Second):
+		t.Fatalf("%s received nothing", e.Id())
+		return "", false
+	}
+}
+
+func TestTopicsRunSideBySide(t *testing.T) {
+	first, second := NewTopic[string](0), NewTopic[string](0)
+	first.Start()
+	second.Start()
+	defer first.Close()
+	defer second.Close()
+
+	a := first.NewEntry("a", DropOldest, 0)
+	b := second.NewEntry("b", DropOldest, 0)
+	first.Notify("to a")
+	second.Notify("to b")
+	if msg, _ := receive(t, a); msg != "to a" {
+		t.Errorf("a received %q", msg)
+	}
+	if msg, _ := receive(t, b); msg != "to b" {
+		t.Errorf("b received %q", msg)
+	}
+}
+
+func TestRemoveReceiverClosesEntry(t *testing.T) {
+	topic := NewTopic[string](0)
+	topic.Start()
+	defer topic.Close()
+
+	e := topic.NewEntry("leaving", DropOldest, 0)
+	topic.RemoveReceiver(e)
+	if _, ok := receive(t, e); ok {
+		t.Error("a removed entry received a message")
+	}
+}
+
+func TestCloseEndsSubscribers(t *testing.T) {
+	topic := NewTopic[string](0)
+	topic.Start()
+	e := topic.NewEntry("subscriber", DropOldest, 0)
+	topic.Notify("hello")
+	if msg, _ := receive(t, e); msg != "hello" {
+		t.Fatalf("received %q", msg)
+	}
+	topic.Close()
+
+	if _, ok := receive(t, e); ok {
+		t.Error("received a message from a closed topic")
+	}
+	// sending to a closed topic must not block
+	topic.Notify("late")
+	topic.Close()
+}
+
+func TestCloseWithoutStart(t *testing.T) {
+	topic := NewTopic[string](0)
+	done := make(chan struct{})
+	go func() {
+		topic.Close()
+		topic.Notify("late")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("closing a topic that never started blocked")
+	}
+}
+
+func TestEntriesEndWhenTheTopicCloses(t *testing.T) {
+	topic := NewTopic[string](0)
+	// queued but never added, as the topic closes before starting
+	queued := topic.NewEntry("queued", DropOldest, 0)
+	topic.Close()
+	late := topic.NewEntry("late", DropOldest, 0)
+	for _, e := range []*TopicEntry[string]{queued, late} {
+		select {
+		case _, ok := <-e.Messages:
+			if ok {
+				t.Errorf("entry %s got a message", e.Id())
+			}
+		case <-time.After(time.Second):
+			t.Errorf("entry %s was not closed", e.Id())
+		}
+	}
+}
+
+func TestEntriesAddedWhileClosingEnd(t *testing.T) {
+	topic := NewTopic[string](0)
+	topic.Start()
+	entries := make(chan *TopicEntry[string], 100)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entries <- topic.NewEntry(strconv.Itoa(i), DropOldest, 0)
+		}()
+	}
+	topic.Close()
+	wg.Wait()
+	close(entries)
+	for e := range entries {
+		select {
+		case <-e.Messages:
+		case <-time.After(time.Second):
+			t.Fatalf("entry %s was still open after the topic closed", e.Id())
+		}
+	}
+}
+
+// drain returns the ids of the messages queued for the entry
+func drain(e *TopicEntry[string]) []uint64 {
+	var ids []uint64
+	for {
+		select {
+		case ev, ok := <-e.Messages:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, ev.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+// settle waits until the topic has processed everything sent to it so far
+func settle(topic *Topic[string]) {
+	topic.Subscribers()
+}
+
+func sendN(topic *Topic[string], n int) {
+	for range n {
+		topic.Notify("hello")
+	}
+	settle(topic)
+}
+
+func ids(from, to uint64) []uint64 {
+	var result []uint64
+	for id := from; id <= to; id++ {
+		result = append(result, id)
+	}
+	return result
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		want    []uint64
+		dropped int64
+		removed bool
+	}{
+		{policy: DropOldest, want: ids(5, 20), dropped: 4},
+		{policy: DropNewest, want: ids(1, 16), dropped: 4},
+		{policy: Disconnect, want: ids(1, 16), dropped: 1, removed: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			topic := NewTopic[string](0)
+			topic.Start()
+			defer topic.Close()
+
+			e := topic.NewEntry("slow", tt.policy, 0)
+			sendN(topic, 20)
+
+			if got := drain(e); !slices.Equal(got, tt.want) {
+				t.Errorf("received %v, expected %v", got, tt.want)
+			}
+			if e.Dropped() != tt.dropped {
+				t.Errorf("dropped %d messages, expected %d", e.Dropped(), tt.dropped)
+			}
+			removed := len(topic.Subscribers()) == 0
+			if removed != tt.removed {
+				t.Errorf("the entry was removed: %t, expected %t", removed, tt.removed)
+			}
+			if tt.removed && topic.Disconnected() != 1 {
+				t.Errorf("counted %d disconnected subscribers", topic.Disconnected())
+			}
+		})
+	}
+}
+
+func TestSlowSubscriberDoesNotHoldUpOthers(t *testing.T) {
+	topic := NewTopic[string](0)
+	topic.Start()
+	defer topic.Close()
+
+	slow := topic.NewEntry("slow", DropNewest, 0)
+	fast := topic.NewEntry("fast", DropNewest, 0)
+	var received []uint64
+	for range 40 {
+		topic.Notify("hello")
+		settle(topic)
+		received = append(received, drain(fast)...)
+	}
+	if !slices.Equal(received, ids(1, 40)) {
+		t.Errorf("the subscriber keeping up received %v", received)
+	}
+	if slow.Dropped() != 24 {
+		t.Errorf("the slow subscriber dropped %d messages, expected 24", slow.Dropped())
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for in, want := range map[string]OverflowPolicy{"": DropOldest, " Drop-Newest ": DropNewest, "disconnect": Disconnect} {
+		if got, err := ParseOverflowPolicy(in); err != nil || got != want {
+			t.Errorf("%q parsed as %q, %v, expected %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseOverflowPolicy("block"); err == nil {
+		t.Error("an unknown overflow policy was accepted")
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	topic := NewTopic[string](5)
+	topic.Start()
+	defer topic.Close()
+	sendN(topic, 8)
+	seen := topic.EventID(Event[string]{ID: 6})
+
+	// the same ids from the run before a restart
+	earlier := NewTopic[string](0)
+	earlier.epoch = "earlier"
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []uint64
+	}{
+		{name: "new subscriber", lastEventID: "", want: ids(4, 8)},
+		{name: "reconnect", lastEventID: seen, want: ids(7, 8)},
+		{name: "up to date", lastEventID: topic.EventID(Event[string]{ID: 8}), want: nil},
+		{name: "earlier run", lastEventID: earlier.EventID(Event[string]{ID: 6}), want: ids(4, 8)},
+		{name: "unsent id", lastEventID: topic.EventID(Event[string]{ID: 120}), want: ids(4, 8)},
+		{name: "not an id", lastEventID: "6", want: ids(4, 8)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := topic.NewEntry(tt.name, DropOldest, topic.ParseEventID(tt.lastEventID))
+			settle(topic)
+			if got := drain(e); !slices.Equal(got, tt.want) {
+				t.Errorf("replayed %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}